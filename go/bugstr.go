@@ -14,20 +14,29 @@
 package bugstr
 
 import (
-	"bytes"
 	"compress/gzip"
 	"context"
+	cryptorand "crypto/rand"
 	"crypto/sha256"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"math/rand"
+	"os"
 	"regexp"
 	"runtime"
+	"runtime/pprof"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
@@ -43,23 +52,258 @@ type Config struct {
 	// Defaults to ["wss://relay.damus.io", "wss://relay.primal.net", "wss://nos.lol"].
 	Relays []string
 
+	// RelaysByEnvironment overrides Relays for reports whose Environment
+	// matches a key, e.g. paid relays for "production" and public ones for
+	// "staging". The report's own Environment is used, so a BeforeSend
+	// that changes it changes the routing too.
+	RelaysByEnvironment map[string][]string
+
+	// RelayProvider, if set, is called for each report to get the current
+	// relays, for apps whose known-good relays change at runtime. An empty
+	// result falls back to RelaysByEnvironment and Relays. It must be safe
+	// for concurrent use.
+	RelayProvider func() []string
+
+	// MaxRelays caps how many relays a report may go to, guarding against
+	// a misconfiguration that sprays every crash across the network. Init
+	// fails if Relays, ArchiveRelays, or a RelaysByEnvironment entry is
+	// longer; RelayProvider results are truncated to it. Defaults to 8.
+	MaxRelays int
+
+	// MinRelaySuccesses is how many relays must accept a report before
+	// delivery counts as done, for durability against a relay purging it.
+	// Relays are tried in order until that many accept; fewer fails with
+	// ErrTooFewRelays. Init rejects values above MaxRelays or the number
+	// of Relays. Defaults to 1.
+	MinRelaySuccesses int
+
+	// RelayConnectTimeout bounds connecting to each relay, so a relay that
+	// hangs on connect doesn't stall delivery to the next one.
+	// Defaults to 10s.
+	RelayConnectTimeout time.Duration
+
+	// RelayPublishInterval spaces consecutive publishes to the same relay
+	// about this far apart (±10% jitter), so a burst of reports isn't
+	// rejected by relays that rate-limit writes (ErrRateLimited). Fatal
+	// reports jump the queue. Zero disables pacing.
+	RelayPublishInterval time.Duration
+
+	// UploadBudgetBytesPerHour caps how many bytes of gift wraps are
+	// published per hour, e.g. to respect a metered mobile data plan.
+	// Every successful upload is charged the serialized event size, so a
+	// report accepted by three relays costs three times its size; relays
+	// that are down or reject the event cost nothing. Reports that wouldn't fit
+	// are dropped with ErrBudgetExceeded and counted in
+	// HealthStatus.BudgetDropped, and relays whose upload no longer fits
+	// are skipped with ErrBudgetExceeded. Fatal reports are always sent
+	// but still count against the budget. Zero means unlimited.
+	UploadBudgetBytesPerHour int
+
+	// PayInvoice, if set, is called with the BOLT11 invoice when a relay
+	// rejects a report with "payment-required:", for paid anti-spam relays.
+	// Return nil once paid and the publish is retried once on that relay.
+	// Called from the delivery goroutine.
+	PayInvoice func(bolt11 string) error
+
+	// CoalesceWindow, if set, collapses duplicate reports (same type,
+	// message, and innermost frame). The first is sent at once; further
+	// duplicates within the window are counted and sent as one report with
+	// Payload.OccurrenceCount when the window closes or on Flush. Applies
+	// to non-fatal background captures; Recover and the Sync variants
+	// always send.
+	CoalesceWindow time.Duration
+
+	// Signer, if set, holds the sender identity instead of a fresh
+	// ephemeral key: it encrypts and signs each report's seal, so a fixed
+	// attribution key can stay in a NIP-46 bunker or hardware signer rather
+	// than in the app. It must support NIP-44 encryption. Init calls
+	// GetPublicKey once. Gift wraps are still signed with one-time keys.
+	Signer nostr.Keyer
+
+	// ThreadReports links each report after a session's first to that
+	// first report with a NIP-10 "root" e tag inside the encrypted rumor,
+	// so receivers can show an incident's cascade of errors as one thread.
+	// A new session ID (see SetSessionID) starts a new thread. The rumors
+	// share the session's sender key.
+	ThreadReports bool
+
+	// OnEvent, if set, is called with each signed event right before it is
+	// published, e.g. to log event IDs or mirror the wire output. Today
+	// that is one kind 1059 gift wrap per report. The event is a copy;
+	// changing it has no effect.
+	OnEvent func(kind int, event *nostr.Event)
+
+	// FallbackWriter, if set, receives the redacted report JSON, one per
+	// line, when it can't be encrypted (ErrEncryptionFailed), so an
+	// encryption failure doesn't lose the report. It is unencrypted:
+	// point it at local storage, not the network.
+	FallbackWriter io.Writer
+
+	// Compression selects how reports are compressed: CompressionGzip
+	// (default), CompressionNone, or CompressionAuto. Receivers decode
+	// every mode, since compressed content is marked by its envelope.
+	Compression Compression
+
+	// Store persists state across restarts for features that need it.
+	// Defaults to a FileStore in the user cache directory.
+	Store Store
+
 	// Environment tag (e.g., "production", "staging").
 	Environment string
 
 	// Release version tag.
 	Release string
 
+	// AutoDetectRelease, when Release is empty, fills Payload.Release with
+	// the main module version and Payload.Build with the VCS revision,
+	// dirty flag, and commit time embedded in the binary. Defaults to true.
+	AutoDetectRelease *bool
+
 	// RedactPatterns are regex patterns for redacting sensitive data.
 	// Defaults include cashu tokens, lightning invoices, and nostr keys.
 	RedactPatterns []*regexp.Regexp
 
+	// RedactReplacement replaces each redacted match. Defaults to "[redacted]".
+	RedactReplacement string
+
+	// RedactReplacer, if set, computes the replacement for each redacted
+	// match, e.g. a typed placeholder like "[lnbc-redacted]" or a short
+	// hash that correlates occurrences without revealing the secret.
+	// Takes precedence over RedactReplacement.
+	RedactReplacer func(match string) string
+
+	// NamedRedactPatterns are redaction patterns whose name appears in the
+	// placeholder, e.g. {"email": ...} redacts to "[redacted:email]", so
+	// triage can tell what kind of data was present without seeing it.
+	// They apply in addition to RedactPatterns (or the defaults).
+	NamedRedactPatterns map[string]*regexp.Regexp
+
 	// BeforeSend allows modifying or filtering payloads before sending.
 	// Return nil to drop the report.
 	BeforeSend func(payload *Payload) *Payload
 
 	// ConfirmSend prompts the user before sending. Return true to send.
-	// If nil, reports are sent automatically (suitable for servers).
+	// If nil, reports are sent automatically (suitable for servers). A
+	// choice remembered with SetConsent answers without prompting.
 	ConfirmSend func(summary Summary) bool
+
+	// IncludeStack controls whether a stack trace and Payload.CaptureSite
+	// are captured and sent. Stack traces can reveal local file paths; set
+	// to Bool(false) to omit them entirely. Defaults to true.
+	IncludeStack *bool
+
+	// TrimStackPaths rewrites absolute source paths in stack traces to
+	// their package import path (like `go build -trimpath`), removing
+	// usernames and machine layout. Defaults to true.
+	TrimStackPaths *bool
+
+	// TrimInternalFrames drops the leading stack frames belonging to
+	// bugstr and the runtime (captureStack, Recover, panic, ...), so the
+	// stack starts at your code. Defaults to true.
+	TrimInternalFrames *bool
+
+	// MaxStackFrames keeps only the top N frames of the stack trace,
+	// bounding report size for deep recursion. Zero means unlimited.
+	MaxStackFrames int
+
+	// MaxMessageLength truncates the report message to at most this many
+	// bytes, keeping its beginning and end, where the actual error reason
+	// often is. Zero means unlimited.
+	MaxMessageLength int
+
+	// MirrorWebhook, if set, receives every report as a JSON POST in
+	// addition to the Nostr delivery. Useful while migrating from an
+	// HTTP-based reporter. Failures on either path don't affect the other;
+	// mirror failures are counted in HealthStatus.MirrorFailed.
+	MirrorWebhook string
+
+	// LocalSink, if set, is a file path every report is appended to as a
+	// JSON line, redacted, regardless of Transport. The file is rotated
+	// to "<path>.1" at 10MB. With a no-op Transport this is a local crash
+	// log needing no relays.
+	LocalSink string
+
+	// Transport delivers reports. Defaults to DefaultTransport, which
+	// gift-wraps reports per NIP-17 and publishes them to Relays.
+	Transport Transport
+
+	// PublicEnvironmentTag adds a public ["t", <environment>] tag to the
+	// outer gift wrap so receivers can filter by environment at the relay
+	// without decrypting. This reveals the environment to anyone reading the
+	// relay, so it is off by default.
+	PublicEnvironmentTag bool
+
+	// CaptureGoroutineLabels copies pprof labels from the context passed to
+	// CaptureExceptionWithContext into Payload.Tags, tying a crash to the
+	// labeled unit of work (e.g. "handler=checkout").
+	CaptureGoroutineLabels bool
+
+	// ReflectPanicValue adds the exported fields of a struct panic value
+	// to Payload.Extra under "panic_value", so typed panics surface their
+	// data rather than only their %v string. Nesting and the total number
+	// of values walked are capped, and cycles are cut. String fields are
+	// redacted.
+	ReflectPanicValue bool
+
+	// DeviceInfoFunc, if set, is called for each report to fill
+	// Payload.Device with platform details Go can't portably read, such
+	// as free disk, battery level, or memory pressure. Values are
+	// redacted.
+	DeviceInfoFunc func() map[string]string
+
+	// IgnoreErrors drops any report whose message matches one of these
+	// patterns, before the payload is built. Useful for known noise such as
+	// "context canceled" or "connection reset by peer".
+	IgnoreErrors []*regexp.Regexp
+
+	// IgnoreTypes drops reports whose error (or panic value) has one of
+	// these concrete type names, as printed by %T, e.g. "*net.OpError".
+	IgnoreTypes []string
+
+	// MinLevel drops captures below this severity, e.g. LevelError to skip
+	// info and warning reports in production. Empty means no threshold.
+	MinLevel Level
+
+	// OnError is called when background delivery of a report fails. The
+	// error wraps ErrAllRelaysFailed, ErrRateLimited, or ErrAuthRequired
+	// where applicable. It runs on the delivery goroutine.
+	OnError func(err error)
+
+	// ArchiveRelays always receive a copy of every gift wrap, published to
+	// all of them regardless of whether the normal relays succeeded. Use for
+	// an archival relay that must see 100% of reports. Archive failures are
+	// passed to OnError wrapping ErrArchiveFailed and don't fail delivery.
+	ArchiveRelays []string
+
+	// VerifyPersistence queries each published gift wrap back from the
+	// relay that accepted it. A relay that accepts but doesn't return the
+	// event counts as failed (ErrNotPersisted), so the next relay is tried.
+	// Catches relays that silently drop events, at the cost of a round trip.
+	VerifyPersistence bool
+
+	// Debug logs each step of the send pipeline (payload built, compression,
+	// per-relay publish results, dropped reports) to Logger, or to stderr at
+	// debug level if Logger is nil. Logs never contain report contents.
+	Debug bool
+
+	// Logger receives Debug logs at slog.LevelDebug. Setting it enables
+	// debug logging even when Debug is false.
+	Logger *slog.Logger
+}
+
+// Bool returns a pointer to v, for setting optional boolean Config fields:
+//
+//	bugstr.Config{IncludeStack: bugstr.Bool(false)}
+func Bool(v bool) *bool {
+	return &v
+}
+
+// boolOr returns *p, or def if p is nil.
+func boolOr(p *bool, def bool) bool {
+	if p == nil {
+		return def
+	}
+	return *p
 }
 
 // Payload is the crash report data sent to the developer.
@@ -69,6 +313,99 @@ type Payload struct {
 	Timestamp   int64  `json:"timestamp"`
 	Environment string `json:"environment,omitempty"`
 	Release     string `json:"release,omitempty"`
+
+	// Level is the report severity. Defaults to LevelError.
+	Level Level `json:"level,omitempty"`
+
+	// Type is the concrete type of the error or panic value, e.g. "*net.OpError".
+	Type string `json:"type,omitempty"`
+
+	// ErrorChain lists each error joined into the reported one (with
+	// errors.Join or several %w verbs), so multi-error results keep their
+	// structure. Nested joins are flattened, up to 20 entries.
+	ErrorChain []ChainedError `json:"error_chain,omitempty"`
+
+	// Tags holds key/value context such as pprof goroutine labels.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Extra holds arbitrary structured data attached via Capture.
+	// String values are redacted.
+	Extra map[string]interface{} `json:"extra,omitempty"`
+
+	// Contexts holds app-specific context set with SetContext, such as
+	// feature flags or an A/B bucket. String values are redacted.
+	Contexts map[string]interface{} `json:"contexts,omitempty"`
+
+	// Build holds the binary's VCS stamps when Config.AutoDetectRelease
+	// applies.
+	Build *BuildInfo `json:"build,omitempty"`
+
+	// Device holds device state at capture time from
+	// Config.DeviceInfoFunc.
+	Device map[string]string `json:"device,omitempty"`
+
+	// ReportID identifies this report instance, so a receiver can drop
+	// copies delivered more than once, e.g. by a retry or by both primary
+	// and archive relays. Unlike a fingerprint, it differs between
+	// separate occurrences of the same crash.
+	ReportID string `json:"report_id,omitempty"`
+
+	// CaptureSite is the file:line of the code that captured the report,
+	// e.g. the CaptureMessage call or the function that panicked. Omitted
+	// with the stack when Config.IncludeStack is false.
+	CaptureSite string `json:"capture_site,omitempty"`
+
+	// Fingerprint groups reports of the same problem. By default it hashes
+	// the type, message, and innermost stack frame; CaptureEvent.Fingerprint
+	// overrides it.
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// OccurrenceCount, when non-zero, means this report stands for that
+	// many duplicate occurrences coalesced within Config.CoalesceWindow
+	// after the first one was sent.
+	OccurrenceCount int `json:"occurrence_count,omitempty"`
+
+	// SDK names the bugstr SDK and version that produced the report, e.g.
+	// "bugstr-go/v0.3.1", for format compatibility and known-bug triage.
+	SDK string `json:"sdk,omitempty"`
+
+	// SessionID identifies the app session the report came from, so
+	// several crashes in a row can be seen as related. See SetSessionID.
+	SessionID string `json:"session_id,omitempty"`
+
+	// Logs holds the recent log lines written to LogTap, oldest first.
+	// Each line is redacted.
+	Logs []string `json:"logs,omitempty"`
+}
+
+// CaptureEvent describes a report with full control over its contents.
+// See Capture.
+type CaptureEvent struct {
+	// Message describes the report. If empty, Err's message is used.
+	// If both are set, the report message is "<Message>: <Err>".
+	Message string
+
+	// Level is the severity. Defaults to LevelError.
+	Level Level
+
+	// Tags are key/value pairs for grouping and filtering.
+	Tags map[string]string
+
+	// Extra is arbitrary structured data, serialized into Payload.Extra.
+	Extra map[string]interface{}
+
+	// Err is the underlying error, if any.
+	Err error
+
+	// Stack overrides the stack captured at the call site, e.g. with one
+	// saved where the error was created. It is still path-trimmed,
+	// frame-limited, and redacted, and omitted if Config.IncludeStack is false.
+	Stack string
+
+	// Fingerprint, if set, replaces the automatic grouping key: reports
+	// with the same components share Payload.Fingerprint and coalesce
+	// together, whatever their messages or stacks.
+	Fingerprint []string
 }
 
 // Summary provides a preview of the crash for confirmation prompts.
@@ -84,31 +421,93 @@ type CompressedEnvelope struct {
 	Payload     string `json:"payload"`
 }
 
-var (
-	config           Config
-	senderPrivkey    string
+// client is the state produced by Init. Its config, keys, and the helpers
+// built from them are never modified after Init publishes it, so captures
+// read them without locking. The mutable fields below (sessionID,
+// threadRoot, consent, contexts, health, inflight, the default store, and
+// FallbackWriter access) are synchronized by atomics or by their own mutex.
+type client struct {
+	config             Config
+	senderPrivkey      string
+	senderPubkeyHex    string
 	developerPubkeyHex string
-	initialized      bool
-	initMu           sync.Mutex
+
+	// logger receives pipeline debug logs; nil disables them.
+	logger *slog.Logger
+
+	// pacer spaces publishes per relay; nil unless RelayPublishInterval is set.
+	pacer *relayPacer
+
+	// budget caps upload bytes; nil unless UploadBudgetBytesPerHour is set.
+	budget *uploadBudget
+
+	// localSink appends reports to a file; nil unless LocalSink is set.
+	localSink *localSink
+
+	// coalescer absorbs duplicate reports; nil unless CoalesceWindow is set.
+	coalescer *coalescer
+
+	// relayPool holds relay connections reused across reports. If nil,
+	// each publish connects afresh.
+	relayPool *relayPool
+
+	// inflight tracks background deliveries for Flush.
+	inflight sync.WaitGroup
+
+	// health records delivery outcomes for Health.
+	health healthStats
+
+	// defaultStore backs store() when Config.Store is nil.
+	defaultStoreOnce sync.Once
+	defaultStore     Store
+
+	// sessionID correlates reports from one app session; see SetSessionID.
+	sessionID atomic.Pointer[string]
+
+	// contexts holds SetContext values, guarded by contextsMu.
+	contextsMu sync.Mutex
+	contexts   map[string]interface{}
+
+	// threadRoot is the session's first delivered report, for
+	// Config.ThreadReports.
+	threadRoot atomic.Pointer[threadRoot]
+
+	// consent is the remembered ConfirmSend answer; nil means ConsentAsk.
+	consent atomic.Pointer[Consent]
+
+	// fallbackMu serializes writes to Config.FallbackWriter.
+	fallbackMu sync.Mutex
+}
+
+var (
+	// active holds the initialized client, or nil before Init. Writes are
+	// serialized by initMu; reads are lock-free so captures never contend.
+	active atomic.Pointer[client]
+	initMu sync.Mutex
 
 	defaultRelays = []string{"wss://relay.damus.io", "wss://relay.primal.net", "wss://nos.lol"}
 
-	defaultRedactions = []*regexp.Regexp{
-		regexp.MustCompile(`cashuA[a-zA-Z0-9]+`),
-		regexp.MustCompile(`(?i)lnbc[a-z0-9]+`),
-		regexp.MustCompile(`(?i)npub1[a-z0-9]+`),
-		regexp.MustCompile(`(?i)nsec1[a-z0-9]+`),
-		regexp.MustCompile(`(?i)https?://[^\s"]*mint[^\s"]*`),
+	// stackBufPool recycles stack capture buffers so a burst of captures
+	// doesn't allocate each time while the process is already struggling.
+	// Pooled buffers stay at pooledStackSize; deeper stacks grow transiently.
+	stackBufPool = sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, pooledStackSize)
+			return &buf
+		},
 	}
 )
 
 // Init initializes Bugstr with the given configuration.
 // Call this early in your application's startup.
+//
+// Returns an error if DeveloperPubkey is missing, is not a valid npub or
+// hex public key, or matches the generated sender key.
 func Init(cfg Config) error {
 	initMu.Lock()
 	defer initMu.Unlock()
 
-	if initialized {
+	if active.Load() != nil {
 		return nil
 	}
 
@@ -116,34 +515,392 @@ func Init(cfg Config) error {
 		return fmt.Errorf("bugstr: DeveloperPubkey is required")
 	}
 
-	config = cfg
+	if err := validateRelayCounts(cfg); err != nil {
+		return err
+	}
+	switch cfg.Compression {
+	case "", CompressionGzip, CompressionNone, CompressionAuto:
+	default:
+		return fmt.Errorf("bugstr: unknown Compression %q", cfg.Compression)
+	}
 
 	// Decode npub to hex if needed
-	developerPubkeyHex = decodePubkey(cfg.DeveloperPubkey)
-	if developerPubkeyHex == "" {
+	developerPubkeyHex := decodePubkey(cfg.DeveloperPubkey)
+	if !nostr.IsValidPublicKey(developerPubkeyHex) {
 		return fmt.Errorf("bugstr: invalid DeveloperPubkey")
 	}
 
-	// Generate ephemeral sender key
-	senderPrivkey = nostr.GeneratePrivateKey()
+	// Generate ephemeral sender key, unless an external signer holds it
+	var senderPrivkey, senderPubkey string
+	var err error
+	if cfg.Signer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), signerTimeout)
+		senderPubkey, err = cfg.Signer.GetPublicKey(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("bugstr: failed to get signer pubkey: %w", err)
+		}
+	} else {
+		senderPrivkey = nostr.GeneratePrivateKey()
+		senderPubkey, err = nostr.GetPublicKey(senderPrivkey)
+		if err != nil {
+			return fmt.Errorf("bugstr: failed to derive sender pubkey: %w", err)
+		}
+	}
+
+	// A Config.Signer holding the developer's own key would send every
+	// report from the developer to the developer, mixing crash reports
+	// into their own DMs, so refuse it. A generated sender key never
+	// matches.
+	if senderPubkey == developerPubkeyHex {
+		return fmt.Errorf("bugstr: DeveloperPubkey must differ from the sender pubkey")
+	}
 
-	initialized = true
+	logger := cfg.Logger
+	if logger == nil && cfg.Debug {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	c := &client{
+		config:             cfg,
+		senderPrivkey:      senderPrivkey,
+		senderPubkeyHex:    senderPubkey,
+		developerPubkeyHex: developerPubkeyHex,
+		logger:             logger,
+		relayPool:          newRelayPool(),
+		coalescer:          newCoalescer(cfg.CoalesceWindow),
+		pacer:              newRelayPacer(cfg.RelayPublishInterval),
+		budget:             newUploadBudget(cfg.UploadBudgetBytesPerHour),
+		localSink:          newLocalSink(cfg.LocalSink),
+	}
+	c.setSessionID(newSessionID())
+	if cfg.ConfirmSend != nil {
+		c.loadConsent()
+	}
+	active.Store(c)
 	return nil
 }
 
+// SetSessionID replaces the session ID attached to subsequent reports as
+// Payload.SessionID. Init generates a random one, so this is only needed to
+// match an app's own session notion. It has no effect before Init; a
+// repeated Init that is a no-op keeps the current ID.
+func SetSessionID(id string) {
+	if c := currentClient(); c != nil {
+		c.setSessionID(id)
+	}
+}
+
+// SetContext attaches value under key to subsequent reports as
+// Payload.Contexts, e.g. SetContext("flags", map[string]bool{"new_ui": true}).
+// A nil value removes key. Values are JSON-encoded at capture time, with
+// strings redacted. It has no effect before Init.
+func SetContext(key string, value interface{}) {
+	c := currentClient()
+	if c == nil {
+		return
+	}
+	c.contextsMu.Lock()
+	defer c.contextsMu.Unlock()
+	if value == nil {
+		delete(c.contexts, key)
+		return
+	}
+	if c.contexts == nil {
+		c.contexts = make(map[string]interface{})
+	}
+	c.contexts[key] = value
+}
+
+// contextsSnapshot returns a copy of the SetContext values.
+func (c *client) contextsSnapshot() map[string]interface{} {
+	c.contextsMu.Lock()
+	defer c.contextsMu.Unlock()
+	if len(c.contexts) == 0 {
+		return nil
+	}
+	snapshot := make(map[string]interface{}, len(c.contexts))
+	for k, v := range c.contexts {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+func (c *client) setSessionID(id string) {
+	c.sessionID.Store(&id)
+}
+
+func (c *client) currentSessionID() string {
+	if id := c.sessionID.Load(); id != nil {
+		return *id
+	}
+	return ""
+}
+
+// newSessionID returns a random 128-bit hex session ID.
+func newSessionID() string {
+	var id [16]byte
+	cryptorand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// MustInit is like Init but panics if the configuration is invalid.
+// It is intended for package init functions, so that panics during package
+// initialization can be reported too:
+//
+//	// package crashreporting
+//	func init() {
+//	    bugstr.MustInit(bugstr.Config{DeveloperPubkey: "npub1..."})
+//	}
+//
+//	// package that may panic during init
+//	import _ "example.com/app/crashreporting"
+//
+//	func init() {
+//	    defer bugstr.Recover()
+//	    // ...
+//	}
+//
+// Go initializes a package's imports before the package itself, so the
+// blank import guarantees bugstr is configured before that init runs.
+// A later Init from main is a no-op.
+func MustInit(cfg Config) {
+	if err := Init(cfg); err != nil {
+		panic(err)
+	}
+}
+
+// currentClient returns the initialized client, or nil before Init.
+// Callers read every setting from the returned snapshot rather than from
+// package globals, so a capture racing Init sees either nothing or a
+// fully initialized configuration.
+func currentClient() *client {
+	return active.Load()
+}
+
+// PanicError is the error reported for a recovered panic. It keeps the
+// original panic value so BeforeSend hooks can inspect it; if the value is
+// itself an error, errors.Is and errors.As see through to it.
+type PanicError struct {
+	Value interface{}
+}
+
+// Error formats the panic value as "panic: <value>".
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 // Recover captures panics and sends a crash report.
 // Use with defer at the top of main() or goroutines:
 //
 //	defer bugstr.Recover()
+//
+// The report is delivered synchronously (bounded by recoverSendTimeout)
+// before Recover re-panics, since the re-panic usually terminates the
+// process before a background send could finish.
+//
+// Recover re-panics with the original panic value unchanged, so errors.As
+// or type switches in an outer recover still see it. However, the stack of
+// the re-panic starts in Recover: the true crash site is in the report's
+// Stack, which is captured while the panicking frames are still live.
+// Recover deliberately re-panics rather than calling runtime.Goexit, which
+// would end only the current goroutine and leave the program running in a
+// possibly inconsistent state.
+//
+// With several Recover defers in one call chain, only the innermost reports
+// the panic; the outer ones see its re-panic and pass it on unreported.
 func Recover() {
 	if r := recover(); r != nil {
-		err := fmt.Errorf("panic: %v", r)
-		CaptureException(err)
+		nested := unwindingFromRecover(r)
+		if c := currentClient(); c != nil && !nested {
+			ctx, cancel := context.WithTimeout(context.Background(), recoverSendTimeout)
+			c.reportError(c.captureSync(ctx, CaptureEvent{Err: &PanicError{Value: r}, Level: LevelFatal}))
+			cancel()
+		}
 		// Re-panic after reporting
+		markRepanicked(r)
 		panic(r)
 	}
 }
 
+// unwindingFromRecover reports whether r, the panic being recovered, is the
+// re-panic of a Recover further up the stack, i.e. one that already
+// reported it. That takes both a live Recover frame in the current
+// goroutine, since the re-panicking Recover's frame stays live while
+// deferred calls run, and r being a value Recover re-panicked during this
+// goroutine's unwinding; a new panic raised by a deferred call has a
+// different value and is reported. Without a live Recover frame no
+// unwinding is in progress, so values left from an earlier one are
+// forgotten. Must be called directly from Recover, RecoverAndContinue, or
+// RecoverAndExit.
+func unwindingFromRecover(r interface{}) bool {
+	pcs := make([]uintptr, 64)
+	// Skip runtime.Callers, this function, and the calling Recover variant.
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	recoverFunc := bugstrPackage() + ".Recover"
+	for {
+		frame, more := frames.Next()
+		if frame.Function == recoverFunc {
+			return wasRepanicked(r)
+		}
+		if !more {
+			forgetRepanicked()
+			return false
+		}
+	}
+}
+
+// maxRepanicking bounds how many goroutines repanicked tracks. An entry
+// outlives its unwinding when the caller recovers the re-panic itself, so
+// entries are evicted once the limit is reached.
+const maxRepanicking = 1024
+
+var (
+	// repanicked holds, by goroutine ID, the values Recover re-panicked
+	// during that goroutine's current unwinding, for unwindingFromRecover.
+	repanickedMu sync.Mutex
+	repanicked   = make(map[uint64][]interface{})
+)
+
+// markRepanicked records that Recover is about to re-panic r.
+func markRepanicked(r interface{}) {
+	id := goroutineID()
+	repanickedMu.Lock()
+	defer repanickedMu.Unlock()
+	values := repanicked[id]
+	for _, v := range values {
+		if samePanicValue(v, r) {
+			return
+		}
+	}
+	if values == nil && len(repanicked) >= maxRepanicking {
+		for evict := range repanicked {
+			delete(repanicked, evict)
+			break
+		}
+	}
+	repanicked[id] = append(values, r)
+}
+
+// wasRepanicked reports whether Recover re-panicked r during the current
+// goroutine's unwinding.
+func wasRepanicked(r interface{}) bool {
+	id := goroutineID()
+	repanickedMu.Lock()
+	defer repanickedMu.Unlock()
+	for _, v := range repanicked[id] {
+		if samePanicValue(v, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetRepanicked ends the current goroutine's unwinding.
+func forgetRepanicked() {
+	id := goroutineID()
+	repanickedMu.Lock()
+	delete(repanicked, id)
+	repanickedMu.Unlock()
+}
+
+// recoverSendTimeout bounds how long Recover blocks delivering a report
+// before re-panicking.
+const recoverSendTimeout = 10 * time.Second
+
+// signerTimeout bounds Init's Config.Signer pubkey lookup, which may be a
+// round trip to a remote signer.
+const signerTimeout = 10 * time.Second
+
+// captureSync builds and delivers a report, blocking until delivery
+// finishes or ctx is done. Returns nil if the report was dropped by a filter
+// or hook.
+func (c *client) captureSync(ctx context.Context, event CaptureEvent) error {
+	payload := c.prepareReport(ctx, event)
+	if payload == nil {
+		return nil
+	}
+	return c.deliver(ctx, payload)
+}
+
+// reportError passes a non-nil delivery error to Config.OnError.
+func (c *client) reportError(err error) {
+	if err != nil && c.config.OnError != nil {
+		c.config.OnError(err)
+	}
+}
+
+// debug logs a send pipeline step if debug logging is enabled.
+func (c *client) debug(msg string, args ...any) {
+	if c.logger != nil {
+		c.logger.Debug("bugstr: "+msg, args...)
+	}
+}
+
+// RecoverAndExit captures panics, delivers the report synchronously, waits
+// for other in-flight reports, then exits with code. Use it in daemons that
+// should die cleanly after a fatal panic without Go's own stack dump, which
+// duplicates the report:
+//
+//	defer bugstr.RecoverAndExit(1)
+//
+// Like any os.Exit, deferred functions further up the stack do not run.
+// Delivery and flushing are each bounded by recoverSendTimeout.
+func RecoverAndExit(code int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	// Only claim a report when one was attempted; before Init nothing is sent.
+	suffix := ""
+	if c := currentClient(); c != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), recoverSendTimeout)
+		if !unwindingFromRecover(r) {
+			c.reportError(c.captureSync(ctx, CaptureEvent{Err: &PanicError{Value: r}, Level: LevelFatal}))
+		}
+		cancel()
+
+		ctx, cancel = context.WithTimeout(context.Background(), recoverSendTimeout)
+		c.flush(ctx)
+		cancel()
+		suffix = " [reported by bugstr]"
+	}
+	fmt.Fprintf(os.Stderr, "panic: %v%s\n", r, suffix)
+	os.Exit(code)
+}
+
+// Flush waits for reports being delivered in the background to finish, or
+// for ctx to be done. Call it before a deliberate shutdown so recent
+// captures aren't lost. Returns ctx.Err() if ctx ended first.
+func Flush(ctx context.Context) error {
+	c := currentClient()
+	if c == nil {
+		return nil
+	}
+	return c.flush(ctx)
+}
+
+func (c *client) flush(ctx context.Context) error {
+	c.flushCoalesced()
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // RecoverAndContinue captures panics without re-panicking.
 // Useful for goroutines that should not crash the program:
 //
@@ -152,24 +909,202 @@ func Recover() {
 //	    // ...
 //	}()
 func RecoverAndContinue() {
-	if r := recover(); r != nil {
-		err := fmt.Errorf("panic: %v", r)
-		CaptureException(err)
+	r := recover()
+	if r == nil {
+		return
+	}
+	nested := unwindingFromRecover(r)
+	// The panic stops here, ending any unwinding Recover was part of.
+	forgetRepanicked()
+	if !nested {
+		CaptureException(&PanicError{Value: r})
 	}
 }
 
 // CaptureException sends an error as a crash report.
 func CaptureException(err error) {
-	if !initialized {
+	CaptureExceptionWithContext(context.Background(), err)
+}
+
+// CaptureExceptionSync sends an error as a crash report and waits for
+// delivery, bounded by ctx. Unlike CaptureException, the delivery error is
+// returned rather than passed to Config.OnError:
+//
+//	if err := bugstr.CaptureExceptionSync(ctx, err); errors.Is(err, bugstr.ErrRateLimited) {
+//	    // try again later
+//	}
+//
+// Returns nil if the report was dropped by a filter, BeforeSend, or
+// ConfirmSend, and ErrNotInitialized before Init.
+func CaptureExceptionSync(ctx context.Context, err error) error {
+	c := currentClient()
+	if c == nil {
+		return ErrNotInitialized
+	}
+	return c.captureSync(ctx, CaptureEvent{Err: err})
+}
+
+// CaptureExceptionAsync sends an error as a crash report in the background,
+// like CaptureException, and returns a channel that receives the delivery
+// result once it is known. The channel is buffered, so it may be ignored:
+//
+//	select {
+//	case err := <-bugstr.CaptureExceptionAsync(err):
+//	    // delivered, or failed with err
+//	case <-time.After(time.Second):
+//	    // still sending; delivery continues in the background
+//	}
+//
+// The result is nil if the report was filtered out or coalesced (see
+// Config.CoalesceWindow), and ErrNotInitialized before Init. As with
+// CaptureExceptionSync, delivery errors go to the channel rather than
+// Config.OnError.
+func CaptureExceptionAsync(err error) <-chan error {
+	result := make(chan error, 1)
+	c := currentClient()
+	if c == nil {
+		result <- ErrNotInitialized
+		return result
+	}
+	payload := c.prepareReport(context.Background(), CaptureEvent{Err: err})
+	if payload == nil || c.coalesce(payload) {
+		result <- nil
+		return result
+	}
+	c.dispatch(payload, result)
+	return result
+}
+
+// CaptureExceptionWithStack sends an error as a crash report using stack
+// instead of the stack at the call site. Use it when reporting an error far
+// from where it occurred, with a stack saved at that point:
+//
+//	stack := string(debug.Stack()) // where the error happened
+//	// ...
+//	bugstr.CaptureExceptionWithStack(err, stack)
+func CaptureExceptionWithStack(err error, stack string) {
+	Capture(CaptureEvent{Err: err, Stack: stack})
+}
+
+// CaptureWithFingerprint sends an error grouped by fingerprint instead of
+// by type, message, and top frame, e.g. so every "database error: <id>"
+// groups together despite the varying ID:
+//
+//	bugstr.CaptureWithFingerprint(err, []string{"database-error"})
+func CaptureWithFingerprint(err error, fingerprint []string) {
+	Capture(CaptureEvent{Err: err, Fingerprint: fingerprint})
+}
+
+// CaptureFromChannel reports each non-nil error received on errs, for
+// worker pools that collect errors on a channel. It blocks until errs is
+// closed or ctx is done; on cancellation, errors already buffered in errs
+// are still reported before it returns. Set Config.CoalesceWindow to
+// collapse repeats of the same error.
+//
+//	go bugstr.CaptureFromChannel(ctx, workerErrs)
+func CaptureFromChannel(ctx context.Context, errs <-chan error) {
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			if err != nil {
+				CaptureException(err)
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case err, ok := <-errs:
+					if !ok {
+						return
+					}
+					if err != nil {
+						CaptureException(err)
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// CaptureExceptionWithContext sends an error as a crash report, using ctx
+// for request-scoped context such as pprof goroutine labels (see
+// Config.CaptureGoroutineLabels). If err is a cancellation or deadline
+// error and ctx is done, context.Cause(ctx) and the deadline are added to
+// Payload.Extra. Cancelling ctx does not cancel delivery.
+func CaptureExceptionWithContext(ctx context.Context, err error) {
+	CaptureWithContext(ctx, CaptureEvent{Err: err})
+}
+
+// Capture sends a report built from event. It is the general form of
+// CaptureException and CaptureMessage:
+//
+//	bugstr.Capture(bugstr.CaptureEvent{
+//	    Message: "checkout failed",
+//	    Level:   bugstr.LevelWarning,
+//	    Tags:    map[string]string{"flow": "checkout"},
+//	    Extra:   map[string]interface{}{"cart_items": 3},
+//	    Err:     err,
+//	})
+func Capture(event CaptureEvent) {
+	CaptureWithContext(context.Background(), event)
+}
+
+// CaptureWithContext is Capture with request-scoped context, as for
+// CaptureExceptionWithContext.
+func CaptureWithContext(ctx context.Context, event CaptureEvent) {
+	c := currentClient()
+	if c == nil {
+		return
+	}
+
+	payload := c.prepareReport(ctx, event)
+	if payload == nil || c.coalesce(payload) {
 		return
 	}
+	c.dispatch(payload, nil)
+}
+
+// prepareReport builds the redacted payload and runs the BeforeSend and
+// ConfirmSend hooks. Returns nil if the report was dropped.
+func (c *client) prepareReport(ctx context.Context, event CaptureEvent) *Payload {
+	if reentrantCapture() {
+		c.debug("report dropped", "reason", "captured from within bugstr")
+		return nil
+	}
+	if c.belowMinLevel(event.Level) {
+		c.debug("report dropped", "reason", "below MinLevel", "level", event.Level)
+		return nil
+	}
+	if c.isIgnored(eventMessage(event)) || c.isIgnoredType(exceptionType(event.Err)) {
+		c.debug("report dropped", "reason", "ignored")
+		return nil
+	}
+
+	payload := c.buildPayload(event)
+	c.debug("payload built", "report_id", payload.ReportID, "level", payload.Level,
+		"message_bytes", len(payload.Message), "stack_bytes", len(payload.Stack))
 
-	payload := buildPayload(err)
+	c.addContextCause(ctx, event.Err, payload)
+
+	if c.config.CaptureGoroutineLabels {
+		if labels := c.goroutineLabels(ctx); labels != nil {
+			// Explicit tags win over labels.
+			for k, v := range payload.Tags {
+				labels[k] = v
+			}
+			payload.Tags = labels
+		}
+	}
 
-	if config.BeforeSend != nil {
-		payload = config.BeforeSend(payload)
+	if c.config.BeforeSend != nil {
+		payload = c.config.BeforeSend(payload)
 		if payload == nil {
-			return
+			c.debug("report dropped", "reason", "BeforeSend returned nil")
+			return nil
 		}
 	}
 
@@ -178,24 +1113,139 @@ func CaptureException(err error) {
 		StackPreview: truncateStack(payload.Stack, 3),
 	}
 
-	if config.ConfirmSend != nil {
-		if !config.ConfirmSend(summary) {
-			return
+	if c.config.ConfirmSend != nil {
+		switch c.currentConsent() {
+		case ConsentAlways:
+		case ConsentNever:
+			c.debug("report dropped", "reason", "consent is never")
+			return nil
+		default:
+			if !c.config.ConfirmSend(summary) {
+				c.debug("report dropped", "reason", "ConfirmSend declined")
+				return nil
+			}
 		}
 	}
 
+	return payload
+}
+
+// addContextCause records why ctx ended in payload.Extra, as
+// "context_cause" and "context_deadline", when err is a cancellation or
+// deadline error and ctx is done, so a bare "context deadline exceeded"
+// report says which cancellation it was.
+func (c *client) addContextCause(ctx context.Context, err error, payload *Payload) {
+	if ctx.Err() == nil || !(errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return
+	}
+	if payload.Extra == nil {
+		payload.Extra = make(map[string]interface{})
+	}
+	if cause := context.Cause(ctx); cause != nil && cause != ctx.Err() {
+		payload.Extra["context_cause"] = c.redact(cause.Error())
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		payload.Extra["context_deadline"] = deadline.UTC().Format(time.RFC3339Nano)
+	}
+	if len(payload.Extra) == 0 {
+		payload.Extra = nil
+	}
+}
+
+// dispatch delivers the payload in the background. The delivery error is
+// sent on result if it is non-nil, and passed to Config.OnError otherwise.
+func (c *client) dispatch(payload *Payload, result chan<- error) {
+	c.inflight.Add(1)
+	c.health.pending.Add(1)
 	go func() {
+		defer c.inflight.Done()
+		defer c.health.pending.Add(-1)
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		if sendErr := sendToNostr(ctx, payload); sendErr != nil {
-			// Silent failure - don't crash the app due to reporting
+		// Never crash the app due to reporting; surface failures via OnError.
+		err := c.deliver(ctx, payload)
+		if result != nil {
+			result <- err
+			return
 		}
+		c.reportError(err)
 	}()
 }
 
-// CaptureMessage sends a message as a crash report.
+// deliver sends the payload via the configured transport and, if set, the
+// mirror webhook, waiting for both. The mirror runs concurrently and its
+// failure doesn't affect the returned transport error.
+func (c *client) deliver(ctx context.Context, payload *Payload) error {
+	var mirror sync.WaitGroup
+	if c.config.MirrorWebhook != "" {
+		mirror.Add(1)
+		go func() {
+			defer mirror.Done()
+			if webhookErr := postWebhook(ctx, c.config.MirrorWebhook, payload); webhookErr != nil {
+				// Mirror delivery is best-effort; only count the failure.
+				c.health.mirrorFailed.Add(1)
+				c.debug("mirror webhook failed", "error", webhookErr)
+			}
+		}()
+	}
+
+	if sinkErr := c.localSink.write(payload); sinkErr != nil {
+		c.debug("local sink write failed", "error", sinkErr)
+	}
+
+	transport := c.config.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	err := transport.Send(ctx, payload)
+	mirror.Wait()
+	c.health.record(err)
+	if err != nil {
+		c.debug("delivery failed", "report_id", payload.ReportID, "error", err)
+	} else {
+		c.debug("report delivered", "report_id", payload.ReportID)
+	}
+	return err
+}
+
+// goroutineLabels returns the pprof labels carried by ctx, redacted, or nil
+// if there are none. Go only exposes labels through the context they were
+// set on (pprof.Do / pprof.WithLabels), not from the running goroutine.
+func (c *client) goroutineLabels(ctx context.Context) map[string]string {
+	var labels map[string]string
+	pprof.ForLabels(ctx, func(key, value string) bool {
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = c.redact(value)
+		return true
+	})
+	return labels
+}
+
+// CaptureMessage sends a message as a crash report at the default level
+// (LevelError).
 func CaptureMessage(msg string) {
-	CaptureException(fmt.Errorf("%s", msg))
+	Capture(CaptureEvent{Message: msg})
+}
+
+// CaptureMessageWithLevel sends a message as a report with the given
+// severity. Captures below Config.MinLevel are dropped.
+func CaptureMessageWithLevel(msg string, level Level) {
+	Capture(CaptureEvent{Message: msg, Level: level})
+}
+
+// CaptureStack sends the current goroutine's stack with message at the
+// given level, for tracing how execution reached an unexpected but
+// non-fatal code path:
+//
+//	bugstr.CaptureStack("cache rebuilt from cold path", bugstr.LevelInfo)
+//
+// The stack is taken at the call site before any other work, so it shows
+// exactly the path that led here. Setting Config.IncludeStack to false
+// still omits it.
+func CaptureStack(message string, level Level) {
+	Capture(CaptureEvent{Message: message, Level: level, Stack: captureStack()})
 }
 
 func decodePubkey(pubkey string) string {
@@ -216,38 +1266,275 @@ func decodePubkey(pubkey string) string {
 	return pubkey
 }
 
-func buildPayload(err error) *Payload {
-	msg := "Unknown error"
-	if err != nil {
-		msg = err.Error()
+// belowMinLevel reports whether a capture at level falls under Config.MinLevel.
+// An empty level is treated as the default level.
+func (c *client) belowMinLevel(level Level) bool {
+	if c.config.MinLevel == "" {
+		return false
+	}
+	if level == "" {
+		level = defaultLevel
+	}
+	return level.rank() < c.config.MinLevel.rank()
+}
+
+// isIgnored reports whether msg matches any Config.IgnoreErrors pattern.
+func (c *client) isIgnored(msg string) bool {
+	for _, p := range c.config.IgnoreErrors {
+		if p.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnoredType reports whether typeName is listed in Config.IgnoreTypes.
+func (c *client) isIgnoredType(typeName string) bool {
+	if typeName == "" {
+		return false
+	}
+	for _, t := range c.config.IgnoreTypes {
+		if t == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// exceptionType returns the concrete type name of err, or of the panic
+// value for a PanicError. Returns "" for a nil error.
+func exceptionType(err error) string {
+	if err == nil {
+		return ""
+	}
+	if pe, ok := err.(*PanicError); ok {
+		return fmt.Sprintf("%T", pe.Value)
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// maxErrorChain caps Payload.ErrorChain entries.
+const maxErrorChain = 20
+
+// ChainedError is one of the errors joined into a reported error.
+type ChainedError struct {
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+}
+
+// joinedErrors returns the leaves of the first multi-error (errors.Join,
+// or fmt.Errorf with several %w verbs) in err's chain, flattening nested
+// joins, or nil if there is none.
+func joinedErrors(err error) []error {
+	for err != nil {
+		if multi, ok := err.(interface{ Unwrap() []error }); ok {
+			var leaves []error
+			for _, e := range multi.Unwrap() {
+				if nested := joinedErrors(e); nested != nil {
+					leaves = append(leaves, nested...)
+				} else if e != nil {
+					leaves = append(leaves, e)
+				}
+			}
+			return leaves
+		}
+		err = errors.Unwrap(err)
+	}
+	return nil
+}
+
+// errorChain returns the redacted entries for err's joined errors.
+func (c *client) errorChain(err error) []ChainedError {
+	leaves := joinedErrors(err)
+	if len(leaves) > maxErrorChain {
+		leaves = leaves[:maxErrorChain]
+	}
+	var chain []ChainedError
+	for _, e := range leaves {
+		chain = append(chain, ChainedError{Type: exceptionType(e), Message: c.redact(e.Error())})
+	}
+	return chain
+}
+
+// eventMessage returns the unredacted report message for event.
+func eventMessage(event CaptureEvent) string {
+	msg := event.Message
+	switch {
+	case event.Err != nil && msg != "":
+		return msg + ": " + event.Err.Error()
+	case event.Err != nil:
+		return event.Err.Error()
+	case msg == "":
+		return "Unknown error"
+	}
+	return msg
+}
+
+// eventExtra returns event.Extra, plus the panic value's exported fields
+// under "panic_value" when Config.ReflectPanicValue is set.
+func (c *client) eventExtra(event CaptureEvent) map[string]interface{} {
+	pe, ok := event.Err.(*PanicError)
+	if !ok || !c.config.ReflectPanicValue {
+		return event.Extra
+	}
+	fields := panicValueFields(pe.Value)
+	if fields == nil {
+		return event.Extra
+	}
+	extra := make(map[string]interface{}, len(event.Extra)+1)
+	for k, v := range event.Extra {
+		extra[k] = v
+	}
+	extra["panic_value"] = fields
+	return extra
+}
+
+// deviceInfo returns the redacted result of Config.DeviceInfoFunc, or nil.
+func (c *client) deviceInfo() map[string]string {
+	if c.config.DeviceInfoFunc == nil {
+		return nil
+	}
+	info := c.config.DeviceInfoFunc()
+	if len(info) == 0 {
+		return nil
+	}
+	device := make(map[string]string, len(info))
+	for k, v := range info {
+		device[k] = c.redact(v)
+	}
+	return device
+}
+
+// eventStack returns the stack to report for event, trimmed and limited per
+// Config but not yet redacted, and the capture site. Both are empty when
+// Config.IncludeStack is false.
+func (c *client) eventStack(event CaptureEvent) (stack, site string) {
+	if !boolOr(c.config.IncludeStack, true) {
+		return "", ""
+	}
+	site = captureSite(boolOr(c.config.TrimStackPaths, true))
+	stack = event.Stack
+	if stack == "" {
+		stack = captureStack()
+	}
+	if boolOr(c.config.TrimInternalFrames, true) {
+		stack = trimInternalFrames(stack)
+	}
+	if boolOr(c.config.TrimStackPaths, true) {
+		stack = trimStackPaths(stack)
+	}
+	return limitStackFrames(stack, c.config.MaxStackFrames), site
+}
+
+func (c *client) buildPayload(event CaptureEvent) *Payload {
+	msg := eventMessage(event)
+
+	level := event.Level
+	if level == "" {
+		level = defaultLevel
 	}
 
-	stack := captureStack()
-	patterns := config.RedactPatterns
-	if len(patterns) == 0 {
-		patterns = defaultRedactions
+	stack, site := c.eventStack(event)
+	var tags map[string]string
+	if len(event.Tags) > 0 {
+		tags = make(map[string]string, len(event.Tags))
+		for k, v := range event.Tags {
+			tags[k] = c.redact(v)
+		}
 	}
 
-	return &Payload{
-		Message:     redact(msg, patterns),
-		Stack:       redact(stack, patterns),
+	payload := &Payload{
+		Message:     truncateMessage(c.redact(msg), c.config.MaxMessageLength),
+		Stack:       c.redact(stack),
 		Timestamp:   time.Now().UnixMilli(),
-		Environment: config.Environment,
-		Release:     config.Release,
+		Environment: c.config.Environment,
+		Release:     c.config.Release,
+		Level:       level,
+		Type:        exceptionType(event.Err),
+		ErrorChain:  c.errorChain(event.Err),
+		Tags:        tags,
+		Extra:       c.redactExtra(c.eventExtra(event)),
+		Contexts:    c.redactExtra(c.contextsSnapshot()),
+		Device:      c.deviceInfo(),
+		CaptureSite: site,
+		SessionID:   c.currentSessionID(),
+		SDK:         sdkName + "/" + sdkVersion(),
+		Logs:        tappedLogs(),
+	}
+	for i, line := range payload.Logs {
+		payload.Logs[i] = c.redact(line)
 	}
+	if payload.Release == "" && boolOr(c.config.AutoDetectRelease, true) {
+		payload.Release, payload.Build = appBuild()
+	}
+	if len(event.Fingerprint) > 0 {
+		payload.Fingerprint = customFingerprint(event.Fingerprint)
+	} else {
+		payload.Fingerprint = fingerprint(payload)
+	}
+	payload.ReportID = newReportID(payload)
+	return payload
 }
 
-func captureStack() string {
-	buf := make([]byte, 64*1024)
-	n := runtime.Stack(buf, false)
-	return string(buf[:n])
+// newReportID derives a report ID from the payload contents and a random
+// per-capture nonce, so two captures of an identical crash get distinct IDs.
+func newReportID(payload *Payload) string {
+	var nonce [16]byte
+	cryptorand.Read(nonce[:])
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00", payload.Message, payload.Stack, payload.Timestamp)
+	h.Write(nonce[:])
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// shortIDEncoding is Crockford's base32 alphabet, which omits I, L, O, and U
+// so codes survive being read aloud or copied by hand.
+var shortIDEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// ShortReportID turns a hex event ID or Payload.ReportID into a short code
+// for users to quote, e.g. "7K3M-QX2A": the first 40 bits of the ID in
+// Crockford base32. Developers find the report by computing ShortReportID
+// over received IDs. Returns "" if id is not at least 10 hex digits.
+func ShortReportID(id string) string {
+	if len(id) < 10 {
+		return ""
+	}
+	prefix, err := hex.DecodeString(id[:10])
+	if err != nil {
+		return ""
+	}
+	code := shortIDEncoding.EncodeToString(prefix)
+	return code[:4] + "-" + code[4:]
+}
+
+// gzipWriterPool recycles gzip writers, whose internal state is several
+// hundred KB, so compressing a report doesn't allocate it afresh.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
 }
 
-func redact(input string, patterns []*regexp.Regexp) string {
-	for _, p := range patterns {
-		input = p.ReplaceAllString(input, "[redacted]")
+const (
+	// pooledStackSize fits the typical goroutine stack trace.
+	pooledStackSize = 8 * 1024
+	// maxStackSize bounds how far captureStack grows for deep stacks.
+	maxStackSize = 64 * 1024
+)
+
+func captureStack() string {
+	bufp := stackBufPool.Get().(*[]byte)
+	defer stackBufPool.Put(bufp)
+
+	buf := *bufp
+	n := runtime.Stack(buf, false)
+	// runtime.Stack truncates silently; a full buffer means there's more.
+	// Grow into a throwaway buffer so the pool keeps its common size.
+	for n == len(buf) && len(buf) < maxStackSize {
+		buf = make([]byte, min(len(buf)*2, maxStackSize))
+		n = runtime.Stack(buf, false)
 	}
-	return input
+	return string(buf[:n])
 }
 
 func truncateStack(stack string, lines int) string {
@@ -258,54 +1545,204 @@ func truncateStack(stack string, lines int) string {
 	return stack
 }
 
+// truncateMessage shortens msg to at most max bytes by replacing its middle
+// with a marker, keeping the head and tail intact. Cuts fall on rune
+// boundaries. A max of zero or less leaves msg unchanged.
+func truncateMessage(msg string, max int) string {
+	if max <= 0 || len(msg) <= max {
+		return msg
+	}
+	marker := fmt.Sprintf("...[%d bytes truncated]...", len(msg)-max)
+	budget := max - len(marker)
+	if budget <= 0 {
+		return msg[:runeStart(msg, max)]
+	}
+	head := runeStart(msg, budget/2)
+	tail := len(msg) - (budget - head)
+	for tail < len(msg) && !utf8.RuneStart(msg[tail]) {
+		tail++
+	}
+	return msg[:head] + marker + msg[tail:]
+}
+
+// runeStart returns the largest rune boundary in s at or before i.
+func runeStart(s string, i int) int {
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
+
+// timestampRand is the jitter source for randomPastTimestamp. It is
+// seeded from crypto/rand so timestamps aren't predictable from the process
+// start time; tests reseed it with seedTimestampRand for determinism.
+var (
+	timestampRandMu sync.Mutex
+	timestampRand   = rand.New(rand.NewSource(cryptoSeed()))
+)
+
+// cryptoSeed returns a random math/rand seed.
+func cryptoSeed() int64 {
+	var b [8]byte
+	cryptorand.Read(b[:])
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// seedTimestampRand makes randomPastTimestamp deterministic. For tests.
+func seedTimestampRand(seed int64) {
+	timestampRandMu.Lock()
+	defer timestampRandMu.Unlock()
+	timestampRand = rand.New(rand.NewSource(seed))
+}
+
+// maxTimestampJitter bounds how far randomPastTimestamp backdates events.
+const maxTimestampJitter = int64(60 * 60 * 24 * 2) // up to 2 days
+
+// randomPastTimestamp returns a Unix time up to maxTimestampJitter in the
+// past, per NIP-59, so event timestamps don't reveal when a crash occurred.
 func randomPastTimestamp() int64 {
 	now := time.Now().Unix()
-	maxOffset := int64(60 * 60 * 24 * 2) // up to 2 days
-	offset := rand.Int63n(maxOffset)
+	timestampRandMu.Lock()
+	offset := timestampRand.Int63n(maxTimestampJitter)
+	timestampRandMu.Unlock()
 	return now - offset
 }
 
-func maybeCompress(plaintext string) string {
-	if len(plaintext) < 1024 {
-		return plaintext
+// randomTimestampSince returns a Unix time between floor and now, so a
+// gift wrap is never dated before the seal it carries.
+func randomTimestampSince(floor int64) int64 {
+	now := time.Now().Unix()
+	if floor >= now {
+		return now
 	}
+	timestampRandMu.Lock()
+	offset := timestampRand.Int63n(now - floor + 1)
+	timestampRandMu.Unlock()
+	return now - offset
+}
 
-	var buf bytes.Buffer
-	gz := gzip.NewWriter(&buf)
-	gz.Write([]byte(plaintext))
-	gz.Close()
+// Compression selects how report content is compressed. See
+// Config.Compression.
+type Compression string
+
+// Compression modes.
+const (
+	// CompressionGzip gzips reports of 1KB or more when that makes them
+	// smaller. The default.
+	CompressionGzip Compression = "gzip"
+	// CompressionNone sends reports uncompressed.
+	CompressionNone Compression = "none"
+	// CompressionAuto gzips every report and sends whichever of the
+	// compressed and uncompressed forms is smaller. It differs from
+	// CompressionGzip only for reports under 1KB.
+	//
+	// zstd is deliberately not a candidate: the SDK would need a new
+	// third-party dependency for it, and existing receivers only decode
+	// gzip envelopes, so a zstd report would be unreadable to them.
+	CompressionAuto Compression = "auto"
+)
 
-	envelope := CompressedEnvelope{
-		V:           1,
-		Compression: "gzip",
-		Payload:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+// encodeContent returns the rumor content for plaintext per
+// Config.Compression.
+func (c *client) encodeContent(plaintext []byte) string {
+	switch c.config.Compression {
+	case CompressionNone:
+		return string(plaintext)
+	case CompressionAuto:
+		if compressed := gzipEnvelope(plaintext); len(compressed) < len(plaintext) {
+			return compressed
+		}
+		return string(plaintext)
+	default:
+		return maybeCompress(plaintext)
 	}
+}
 
-	result, _ := json.Marshal(envelope)
-	return string(result)
+// maybeCompress returns a gzip CompressedEnvelope for plaintext of 1KB or
+// more, unless base64 inflation on barely compressible data makes the
+// envelope no smaller than plaintext. Otherwise plaintext is returned
+// unchanged.
+func maybeCompress(plaintext []byte) string {
+	if len(plaintext) < 1024 {
+		return string(plaintext)
+	}
+	if envelope := gzipEnvelope(plaintext); len(envelope) < len(plaintext) {
+		return envelope
+	}
+	return string(plaintext)
 }
 
-func sendToNostr(ctx context.Context, payload *Payload) error {
-	relays := config.Relays
-	if len(relays) == 0 {
-		relays = defaultRelays
+// gzipEnvelope returns plaintext gzipped in a CompressedEnvelope. The
+// envelope is streamed: gzip output feeds a base64 encoder writing straight
+// into the envelope JSON, so the compressed bytes are never held separately
+// from their encoded form.
+func gzipEnvelope(plaintext []byte) string {
+	var buf strings.Builder
+	// Base64 output needs no JSON escaping, so the envelope can be written
+	// directly. Field order matches CompressedEnvelope.
+	buf.WriteString(`{"v":1,"compression":"gzip","payload":"`)
+	b64 := base64.NewEncoder(base64.StdEncoding, &buf)
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(b64)
+	gz.Write(plaintext)
+	gz.Close()
+	gzipWriterPool.Put(gz)
+	b64.Close()
+	buf.WriteString(`"}`)
+	return buf.String()
+}
+
+// DecompressEnvelope reverses the SDK's content compression for receivers:
+// given a rumor's content, it returns the report JSON. Content that is not
+// a CompressedEnvelope, i.e. reports sent uncompressed, is returned as is.
+// Envelopes with an unknown version or codec are an error.
+func DecompressEnvelope(content string) (string, error) {
+	var envelope CompressedEnvelope
+	if json.Unmarshal([]byte(content), &envelope) != nil || envelope.Compression == "" {
+		return content, nil
+	}
+	if envelope.V != 1 {
+		return "", fmt.Errorf("bugstr: unsupported envelope version %d", envelope.V)
+	}
+	if envelope.Compression != "gzip" {
+		return "", fmt.Errorf("bugstr: unsupported compression %q", envelope.Compression)
+	}
+	gz, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, strings.NewReader(envelope.Payload)))
+	if err != nil {
+		return "", fmt.Errorf("bugstr: decompress envelope: %w", err)
 	}
+	defer gz.Close()
+	plaintext, err := io.ReadAll(gz)
+	if err != nil {
+		return "", fmt.Errorf("bugstr: decompress envelope: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+func (c *client) sendToNostr(ctx context.Context, payload *Payload) error {
+	relays := c.relaysFor(payload.Environment)
 
 	plaintext, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	content := maybeCompress(string(plaintext))
-	senderPubkey, _ := nostr.GetPublicKey(senderPrivkey)
+	content := c.encodeContent(plaintext)
+	c.debug("payload encoded", "json_bytes", len(plaintext), "content_bytes", len(content))
 
-	// Build unsigned kind 14 rumor
+	// Build unsigned kind 14 rumor. Per NIP-17 the rumor, which only the
+	// developer can read, carries the real capture time; the seal and gift
+	// wrap are backdated.
+	rumorCreatedAt := time.Now().Unix()
+	if payload.Timestamp > 0 {
+		rumorCreatedAt = payload.Timestamp / 1000
+	}
 	rumor := map[string]interface{}{
 		"id":         "", // Computed later
-		"pubkey":     senderPubkey,
-		"created_at": randomPastTimestamp(),
+		"pubkey":     c.senderPubkeyHex,
+		"created_at": rumorCreatedAt,
 		"kind":       14,
-		"tags":       [][]string{{"p", developerPubkeyHex}},
+		"tags":       c.rumorTags(payload.SessionID),
 		"content":    content,
 		"sig":        "",
 	}
@@ -323,61 +1760,246 @@ func sendToNostr(ctx context.Context, payload *Payload) error {
 	rumorID := hex.EncodeToString(hash[:])
 	rumor["id"] = rumorID
 
-	// Encrypt rumor into seal
 	rumorBytes, _ := json.Marshal(rumor)
-	conversationKey, err := nip44.GenerateConversationKey(senderPrivkey, developerPubkeyHex)
+	giftWrap, err := c.wrapRumor(ctx, payload, rumorBytes)
 	if err != nil {
+		return c.encryptionFailed(plaintext, err)
+	}
+
+	if payload.Level == LevelFatal {
+		ctx = withUrgent(ctx)
+	}
+	if !c.budget.fits(ctx, len(giftWrap.String())) {
+		c.health.budgetDropped.Add(1)
+		return ErrBudgetExceeded
+	}
+
+	if c.config.OnEvent != nil {
+		event := giftWrap
+		c.config.OnEvent(event.Kind, &event)
+	}
+
+	var archived sync.WaitGroup
+	if len(c.config.ArchiveRelays) > 0 {
+		archived.Add(1)
+		go func() {
+			defer archived.Done()
+			c.reportError(archiveErrors(c.publishToAllRelays(ctx, c.config.ArchiveRelays, giftWrap)))
+		}()
+	}
+
+	err = c.publishToRelays(ctx, relays, giftWrap)
+	archived.Wait()
+	if err == nil {
+		recordEventID(ctx, giftWrap.ID)
+		c.setThreadRoot(payload.SessionID, rumorID)
+	}
+	return err
+}
+
+// threadRoot is the first delivered rumor of a session; see
+// Config.ThreadReports.
+type threadRoot struct {
+	sessionID string
+	rumorID   string
+}
+
+// rumorTags returns the rumor tags: the developer p tag and, with
+// Config.ThreadReports, a NIP-10 root e tag for the session's first report.
+func (c *client) rumorTags(sessionID string) [][]string {
+	tags := [][]string{{"p", c.developerPubkeyHex}}
+	if !c.config.ThreadReports {
+		return tags
+	}
+	if root := c.threadRoot.Load(); root != nil && root.sessionID == sessionID {
+		tags = append(tags, []string{"e", root.rumorID, "", "root"})
+	}
+	return tags
+}
+
+// setThreadRoot records rumorID as the session's thread root unless the
+// session already has one.
+func (c *client) setThreadRoot(sessionID, rumorID string) {
+	if !c.config.ThreadReports {
+		return
+	}
+	for {
+		old := c.threadRoot.Load()
+		if old != nil && old.sessionID == sessionID {
+			return
+		}
+		if c.threadRoot.CompareAndSwap(old, &threadRoot{sessionID: sessionID, rumorID: rumorID}) {
+			return
+		}
+	}
+}
+
+// defaultMaxRelays is the Config.MaxRelays default.
+const defaultMaxRelays = 8
+
+func maxRelays(cfg Config) int {
+	if cfg.MaxRelays > 0 {
+		return cfg.MaxRelays
+	}
+	return defaultMaxRelays
+}
+
+// validateRelayCounts checks the static relay lists against MaxRelays and
+// MinRelaySuccesses against both.
+func validateRelayCounts(cfg Config) error {
+	limit := maxRelays(cfg)
+	check := func(name string, relays []string) error {
+		if len(relays) > limit {
+			return fmt.Errorf("bugstr: %s has %d relays, more than MaxRelays (%d)", name, len(relays), limit)
+		}
+		return nil
+	}
+	if err := check("Relays", cfg.Relays); err != nil {
 		return err
 	}
-	sealContent, err := nip44.Encrypt(string(rumorBytes), conversationKey)
-	if err != nil {
+	if err := check("ArchiveRelays", cfg.ArchiveRelays); err != nil {
 		return err
 	}
+	for env, relays := range cfg.RelaysByEnvironment {
+		if err := check(fmt.Sprintf("RelaysByEnvironment[%q]", env), relays); err != nil {
+			return err
+		}
+	}
+	switch {
+	case cfg.MinRelaySuccesses < 0:
+		return fmt.Errorf("bugstr: negative MinRelaySuccesses (%d)", cfg.MinRelaySuccesses)
+	case cfg.MinRelaySuccesses > limit:
+		return fmt.Errorf("bugstr: MinRelaySuccesses (%d) exceeds MaxRelays (%d)", cfg.MinRelaySuccesses, limit)
+	case len(cfg.Relays) > 0 && cfg.MinRelaySuccesses > len(cfg.Relays):
+		return fmt.Errorf("bugstr: MinRelaySuccesses (%d) exceeds the %d Relays", cfg.MinRelaySuccesses, len(cfg.Relays))
+	}
+	return nil
+}
 
-	seal := nostr.Event{
-		Kind:      13,
-		CreatedAt: nostr.Timestamp(randomPastTimestamp()),
-		Tags:      nostr.Tags{},
-		Content:   sealContent,
+// wrapRumor seals rumor and wraps the seal in a signed gift wrap. A panic
+// while encrypting, e.g. from a Config.Signer or a malformed key, is
+// returned as an error so the report is dropped rather than crashing the
+// sending goroutine.
+func (c *client) wrapRumor(ctx context.Context, payload *Payload, rumor []byte) (giftWrap nostr.Event, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	// Encrypt rumor into seal
+	seal, err := c.seal(ctx, string(rumor))
+	if err != nil {
+		return giftWrap, err
 	}
-	seal.Sign(senderPrivkey)
 
 	// Wrap seal in gift wrap with random key
 	wrapperPrivkey := nostr.GeneratePrivateKey()
-	wrapKey, err := nip44.GenerateConversationKey(wrapperPrivkey, developerPubkeyHex)
+	wrapKey, err := nip44.GenerateConversationKey(c.developerPubkeyHex, wrapperPrivkey)
 	if err != nil {
-		return err
+		return giftWrap, err
 	}
 
 	sealJSON, _ := json.Marshal(seal)
 	giftContent, err := nip44.Encrypt(string(sealJSON), wrapKey)
 	if err != nil {
-		return err
+		return giftWrap, err
 	}
 
-	giftWrap := nostr.Event{
+	giftTags := nostr.Tags{{"p", c.developerPubkeyHex}}
+	if c.config.PublicEnvironmentTag && payload.Environment != "" {
+		giftTags = append(giftTags, nostr.Tag{"t", payload.Environment})
+	}
+
+	giftWrap = nostr.Event{
 		Kind:      1059,
-		CreatedAt: nostr.Timestamp(randomPastTimestamp()),
-		Tags:      nostr.Tags{{"p", developerPubkeyHex}},
+		CreatedAt: nostr.Timestamp(randomTimestampSince(int64(seal.CreatedAt))),
+		Tags:      giftTags,
 		Content:   giftContent,
 	}
 	giftWrap.Sign(wrapperPrivkey)
+	return giftWrap, nil
+}
+
+// encryptionFailed writes the report JSON to Config.FallbackWriter, if
+// set, and returns err wrapped with ErrEncryptionFailed.
+func (c *client) encryptionFailed(plaintext []byte, err error) error {
+	err = fmt.Errorf("%w: %w", ErrEncryptionFailed, err)
+	w := c.config.FallbackWriter
+	if w == nil {
+		return err
+	}
+	c.fallbackMu.Lock()
+	defer c.fallbackMu.Unlock()
+	if _, writeErr := w.Write(append(plaintext, '\n')); writeErr != nil {
+		c.debug("fallback write failed", "error", writeErr)
+	} else {
+		c.debug("report written to fallback writer")
+	}
+	return err
+}
 
-	// Publish to relays
-	var lastErr error
-	for _, relayURL := range relays {
-		relay, err := nostr.RelayConnect(ctx, relayURL)
+// seal encrypts the rumor to the developer and signs the kind 13 seal,
+// with Config.Signer if set and the ephemeral sender key otherwise.
+func (c *client) seal(ctx context.Context, rumor string) (nostr.Event, error) {
+	seal := nostr.Event{
+		Kind:      13,
+		CreatedAt: nostr.Timestamp(randomPastTimestamp()),
+		Tags:      nostr.Tags{},
+	}
+	if signer := c.config.Signer; signer != nil {
+		content, err := signer.Encrypt(ctx, rumor, c.developerPubkeyHex)
 		if err != nil {
-			lastErr = err
-			continue
+			return seal, fmt.Errorf("bugstr: signer encrypt: %w", err)
 		}
-		err = relay.Publish(ctx, giftWrap)
-		relay.Close()
-		if err == nil {
-			return nil
+		seal.Content = content
+		if err := signer.SignEvent(ctx, &seal); err != nil {
+			return seal, fmt.Errorf("bugstr: signer sign: %w", err)
 		}
-		lastErr = err
+		return seal, nil
 	}
 
-	return lastErr
+	conversationKey, err := nip44.GenerateConversationKey(c.developerPubkeyHex, c.senderPrivkey)
+	if err != nil {
+		return seal, err
+	}
+	seal.Content, err = nip44.Encrypt(rumor, conversationKey)
+	if err != nil {
+		return seal, err
+	}
+	return seal, seal.Sign(c.senderPrivkey)
+}
+
+// relaysFor returns the relays to publish a report from environment to:
+// the Config.RelayProvider result if non-empty, else
+// Config.RelaysByEnvironment[environment] if set, else Config.Relays, else
+// the defaults.
+func (c *client) relaysFor(environment string) []string {
+	if c.config.RelayProvider != nil {
+		if relays := c.config.RelayProvider(); len(relays) > 0 {
+			return relays[:min(len(relays), maxRelays(c.config))]
+		}
+	}
+	if relays := c.config.RelaysByEnvironment[environment]; len(relays) > 0 {
+		return relays
+	}
+	if len(c.config.Relays) > 0 {
+		return c.config.Relays
+	}
+	return defaultRelays
+}
+
+// archiveErrors folds per-relay archive results into one error wrapping
+// ErrArchiveFailed, or nil if every archive relay accepted the event.
+func archiveErrors(results map[string]error) error {
+	var errs []error
+	for relayURL, err := range results {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", relayURL, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrArchiveFailed, errors.Join(errs...))
 }
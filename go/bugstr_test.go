@@ -0,0 +1,586 @@
+package bugstr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func BenchmarkCaptureStack(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = captureStack()
+	}
+}
+
+func BenchmarkCaptureStackDeep(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = deepStack(200)
+	}
+}
+
+func deepStack(depth int) string {
+	if depth == 0 {
+		return captureStack()
+	}
+	return deepStack(depth - 1)
+}
+
+func TestCaptureStackGrowsPastPooledSize(t *testing.T) {
+	stack := deepStack(200)
+	if len(stack) <= pooledStackSize {
+		t.Fatalf("expected deep stack beyond %d bytes, got %d", pooledStackSize, len(stack))
+	}
+	if !strings.Contains(stack, "deepStack") {
+		t.Fatalf("stack missing recursive frames: %q", stack[:200])
+	}
+}
+
+func BenchmarkBuildPayloadSmall(b *testing.B) {
+	c := &client{}
+	event := CaptureEvent{Err: errors.New("connection refused while loading wallet")}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = c.buildPayload(event)
+	}
+}
+
+func BenchmarkMaybeCompressSmall(b *testing.B) {
+	plaintext := []byte(`{"message":"connection refused","timestamp":1700000000000}`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = maybeCompress(plaintext)
+	}
+}
+
+func BenchmarkMaybeCompressLarge(b *testing.B) {
+	plaintext := []byte(strings.Repeat("goroutine 1 [running]:\nmain.main()\n", 256))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = maybeCompress(plaintext)
+	}
+}
+
+func TestGoroutineLabels(t *testing.T) {
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("handler", "checkout", "invoice", "lnbc1abc"))
+	c := &client{}
+	labels := c.goroutineLabels(ctx)
+	if labels["handler"] != "checkout" {
+		t.Fatalf("missing handler label: %v", labels)
+	}
+	if labels["invoice"] != "[redacted]" {
+		t.Fatalf("label value not redacted: %v", labels)
+	}
+	if got := c.goroutineLabels(context.Background()); got != nil {
+		t.Fatalf("expected nil labels without pprof context, got %v", got)
+	}
+}
+
+func TestBuildPayloadFromCaptureEvent(t *testing.T) {
+	c := &client{}
+	payload := c.buildPayload(CaptureEvent{
+		Message: "checkout failed",
+		Level:   LevelWarning,
+		Tags:    map[string]string{"flow": "checkout"},
+		Extra: map[string]interface{}{
+			"cart":  map[string]interface{}{"token": "cashuAeyJ0b2tlbiI6"},
+			"items": 3,
+		},
+		Err: errors.New("timeout"),
+	})
+
+	if payload.Message != "checkout failed: timeout" {
+		t.Fatalf("Message = %q", payload.Message)
+	}
+	if payload.Level != LevelWarning {
+		t.Fatalf("Level = %q", payload.Level)
+	}
+	if payload.Tags["flow"] != "checkout" {
+		t.Fatalf("Tags = %v", payload.Tags)
+	}
+	cart := payload.Extra["cart"].(map[string]interface{})
+	if cart["token"] != "[redacted]" {
+		t.Fatalf("nested extra not redacted: %v", cart)
+	}
+	if payload.Extra["items"] != float64(3) {
+		t.Fatalf("Extra[items] = %v", payload.Extra["items"])
+	}
+
+	if got := c.buildPayload(CaptureEvent{}).Level; got != LevelError {
+		t.Fatalf("default Level = %q", got)
+	}
+}
+
+func TestIsIgnored(t *testing.T) {
+	c := &client{config: Config{IgnoreErrors: []*regexp.Regexp{regexp.MustCompile(`context canceled$`)}}}
+
+	if !c.isIgnored(eventMessage(CaptureEvent{Err: context.Canceled})) {
+		t.Fatal("expected context.Canceled to be ignored")
+	}
+	if c.isIgnored(eventMessage(CaptureEvent{Message: "disk full"})) {
+		t.Fatal("unexpected ignore of unrelated message")
+	}
+}
+
+func TestExceptionType(t *testing.T) {
+	if got := exceptionType(&PanicError{Value: "boom"}); got != "string" {
+		t.Fatalf("panic value type = %q", got)
+	}
+	if got := exceptionType(context.Canceled); got != "*errors.errorString" {
+		t.Fatalf("error type = %q", got)
+	}
+	if got := exceptionType(nil); got != "" {
+		t.Fatalf("nil error type = %q", got)
+	}
+
+	wrapped := &PanicError{Value: context.Canceled}
+	if !errors.Is(wrapped, context.Canceled) {
+		t.Fatal("PanicError should unwrap an error panic value")
+	}
+}
+
+func TestBelowMinLevel(t *testing.T) {
+	c := &client{config: Config{MinLevel: LevelError}}
+
+	if !c.belowMinLevel(LevelWarning) {
+		t.Fatal("warning should be below error threshold")
+	}
+	if c.belowMinLevel(LevelFatal) || c.belowMinLevel(LevelError) || c.belowMinLevel("") {
+		t.Fatal("error, fatal, and default level should pass an error threshold")
+	}
+}
+
+func TestBuildPayloadUsesProvidedStack(t *testing.T) {
+	c := &client{}
+	saved := "goroutine 7 [running]:\nmain.origin()\n\t/home/bob/app/main.go:9 +0x1\n"
+	payload := c.buildPayload(CaptureEvent{Err: errors.New("late"), Stack: saved})
+	if !strings.Contains(payload.Stack, "main.origin()") || strings.Contains(payload.Stack, "/home/bob") {
+		t.Fatalf("expected trimmed provided stack, got:\n%s", payload.Stack)
+	}
+
+	c.config.IncludeStack = Bool(false)
+	if got := c.buildPayload(CaptureEvent{Stack: saved}).Stack; got != "" {
+		t.Fatalf("IncludeStack=false should drop provided stack, got %q", got)
+	}
+}
+
+func TestMaybeCompressEnvelope(t *testing.T) {
+	small := []byte(`{"message":"tiny"}`)
+	if got := maybeCompress(small); got != string(small) {
+		t.Fatalf("small payload should pass through, got %q", got)
+	}
+
+	large := strings.Repeat("goroutine 1 [running]:\n", 100)
+	content := maybeCompress([]byte(large))
+	var envelope CompressedEnvelope
+	if err := json.Unmarshal([]byte(content), &envelope); err != nil {
+		t.Fatalf("envelope is not valid JSON: %v", err)
+	}
+	if envelope.V != 1 || envelope.Compression != "gzip" {
+		t.Fatalf("unexpected envelope header: %+v", envelope)
+	}
+	if got := decodeContent(t, content); got != large {
+		t.Fatal("decompressed payload does not match original")
+	}
+}
+
+func TestMaybeCompressSkipsInflatingEnvelope(t *testing.T) {
+	random := make([]byte, 768)
+	rand.New(rand.NewSource(1)).Read(random)
+	incompressible := []byte(`{"message":"` + base64.StdEncoding.EncodeToString(random) + `"}`)
+	if len(incompressible) < 1024 {
+		t.Fatalf("test payload is only %d bytes", len(incompressible))
+	}
+	if got := maybeCompress(incompressible); got != string(incompressible) {
+		t.Fatalf("envelope of %d bytes should lose to %d bytes of plaintext", len(got), len(incompressible))
+	}
+}
+
+func TestDecompressEnvelope(t *testing.T) {
+	plain := `{"message":"tiny"}`
+	if got, err := DecompressEnvelope(plain); err != nil || got != plain {
+		t.Fatalf("plaintext should pass through: %q, %v", got, err)
+	}
+	if got, err := DecompressEnvelope("not json"); err != nil || got != "not json" {
+		t.Fatalf("non-JSON should pass through: %q, %v", got, err)
+	}
+
+	large := `{"message":"` + strings.Repeat("x", 2000) + `"}`
+	if got, err := DecompressEnvelope(maybeCompress([]byte(large))); err != nil || got != large {
+		t.Fatalf("round trip failed: %v", err)
+	}
+
+	for _, bad := range []string{
+		`{"v":2,"compression":"gzip","payload":""}`,
+		`{"v":1,"compression":"zstd","payload":""}`,
+		`{"v":1,"compression":"gzip","payload":"!!"}`,
+	} {
+		if _, err := DecompressEnvelope(bad); err == nil {
+			t.Errorf("DecompressEnvelope(%s) should fail", bad)
+		}
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	c := &client{}
+	joined := fmt.Errorf("sync failed: %w", errors.Join(
+		errors.New("shard 1: timeout"),
+		errors.Join(&PanicError{Value: 42}, errors.New("token lnbc1abc rejected")),
+	))
+	chain := c.buildPayload(CaptureEvent{Err: joined}).ErrorChain
+	want := []ChainedError{
+		{Type: "*errors.errorString", Message: "shard 1: timeout"},
+		{Type: "int", Message: "panic: 42"},
+		{Type: "*errors.errorString", Message: "token [redacted] rejected"},
+	}
+	if len(chain) != len(want) {
+		t.Fatalf("ErrorChain = %+v", chain)
+	}
+	for i := range want {
+		if chain[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, chain[i], want[i])
+		}
+	}
+	if chain := c.buildPayload(CaptureEvent{Err: errors.New("single")}).ErrorChain; chain != nil {
+		t.Fatalf("single error should have no chain, got %+v", chain)
+	}
+}
+
+func TestShortReportID(t *testing.T) {
+	id := "0000000000" + strings.Repeat("f", 54)
+	if got := ShortReportID(id); got != "0000-0000" {
+		t.Fatalf("ShortReportID = %q", got)
+	}
+	if got := ShortReportID("ffffffffff"); got != "ZZZZ-ZZZZ" {
+		t.Fatalf("ShortReportID = %q", got)
+	}
+	if ShortReportID("abc") != "" || ShortReportID("not-hex-at-all") != "" {
+		t.Fatal("invalid IDs should give empty codes")
+	}
+	code := ShortReportID(newReportID(&Payload{Message: "m"}))
+	if len(code) != 9 || strings.ContainsAny(code, "ILOU") {
+		t.Fatalf("unexpected code %q", code)
+	}
+}
+
+func TestContextCauseInExtra(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var reported *Payload
+	active.Store(&client{config: Config{Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+		reported = p
+		return nil
+	})}})
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Nanosecond, errors.New("inventory lookup for nsec1abc took too long"))
+	defer cancel()
+	<-ctx.Done()
+	CaptureExceptionWithContext(ctx, fmt.Errorf("fetch: %w", ctx.Err()))
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if reported == nil || reported.Extra["context_cause"] != "inventory lookup for [redacted] took too long" {
+		t.Fatalf("Extra = %v", reported.Extra)
+	}
+	if _, ok := reported.Extra["context_deadline"]; !ok {
+		t.Fatal("deadline should be reported")
+	}
+
+	reported = nil
+	CaptureExceptionWithContext(ctx, errors.New("unrelated"))
+	Flush(context.Background())
+	if reported == nil || reported.Extra != nil {
+		t.Fatalf("non-cancellation errors get no cause: %v", reported)
+	}
+}
+
+func TestRedactReplacement(t *testing.T) {
+	c := &client{config: Config{RedactReplacement: "***"}}
+	if got := c.redact("pay lnbc1abc now"); got != "pay *** now" {
+		t.Fatalf("RedactReplacement: got %q", got)
+	}
+
+	c.config.RedactReplacer = func(match string) string { return "[" + match[:4] + "-redacted]" }
+	if got := c.redact("pay lnbc1abc now"); got != "pay [lnbc-redacted] now" {
+		t.Fatalf("RedactReplacer: got %q", got)
+	}
+}
+
+func TestNamedRedactPatterns(t *testing.T) {
+	c := &client{config: Config{NamedRedactPatterns: map[string]*regexp.Regexp{
+		"email": regexp.MustCompile(`[a-z]+@[a-z]+\.com`),
+		"nsec":  regexp.MustCompile(`nsec1[a-z0-9]+`),
+	}}}
+	got := c.redact("key nsec1abc from bob@example.com, invoice lnbc1xyz")
+	want := "key [redacted:nsec] from [redacted:email], invoice [redacted]"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPreviewRedaction(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	original, redacted := PreviewRedaction(errors.New("paying lnbc1abc failed"))
+	if !strings.HasPrefix(original, "paying lnbc1abc failed\n\n") {
+		t.Fatalf("original = %q", original)
+	}
+	if !strings.HasPrefix(redacted, "paying [redacted] failed\n\n") {
+		t.Fatalf("redacted = %q", redacted)
+	}
+	if !strings.Contains(original, "TestPreviewRedaction") || strings.Contains(original, "bugstr.PreviewRedaction") {
+		t.Fatalf("stack should start at the caller:\n%s", original)
+	}
+
+	active.Store(&client{config: Config{IncludeStack: Bool(false), RedactReplacement: "***"}})
+	original, redacted = PreviewRedaction(errors.New("lnbc1abc"))
+	if original != "lnbc1abc" || redacted != "***" {
+		t.Fatalf("with config: %q, %q", original, redacted)
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	SetContext("ignored", "before init")
+	c := &client{}
+	active.Store(c)
+	SetContext("flags", map[string]bool{"new_ui": true})
+	SetContext("owner", "npub1abc")
+	SetContext("bucket", "b")
+	SetContext("bucket", nil)
+
+	p := c.buildPayload(CaptureEvent{Message: "with context"})
+	flags, _ := p.Contexts["flags"].(map[string]interface{})
+	if flags["new_ui"] != true || p.Contexts["owner"] != "[redacted]" {
+		t.Fatalf("Contexts = %v", p.Contexts)
+	}
+	if _, ok := p.Contexts["bucket"]; ok {
+		t.Fatal("nil value should remove the key")
+	}
+}
+
+func TestDeviceInfoFunc(t *testing.T) {
+	c := &client{config: Config{DeviceInfoFunc: func() map[string]string {
+		return map[string]string{"free_disk_mb": "12", "owner": "npub1xyz"}
+	}}}
+	p := c.buildPayload(CaptureEvent{Message: "low disk"})
+	if p.Device["free_disk_mb"] != "12" || p.Device["owner"] != "[redacted]" {
+		t.Fatalf("Device = %v", p.Device)
+	}
+	if p := (&client{}).buildPayload(CaptureEvent{Message: "no hook"}); p.Device != nil {
+		t.Fatalf("Device without hook = %v", p.Device)
+	}
+}
+
+func TestReportIDUniquePerCapture(t *testing.T) {
+	c := &client{config: Config{}}
+	first := c.buildPayload(CaptureEvent{Message: "same crash", Stack: "stack"})
+	second := c.buildPayload(CaptureEvent{Message: "same crash", Stack: "stack"})
+	if len(first.ReportID) != 32 {
+		t.Fatalf("unexpected report ID %q", first.ReportID)
+	}
+	if first.ReportID == second.ReportID {
+		t.Fatal("separate captures must get distinct report IDs")
+	}
+}
+
+func TestDebugLogsPipeline(t *testing.T) {
+	var logs bytes.Buffer
+	c := &client{
+		config: Config{Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			return nil
+		})},
+		logger: slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	if err := c.captureSync(context.Background(), CaptureEvent{Message: "secret nsec1abc"}); err != nil {
+		t.Fatalf("captureSync: %v", err)
+	}
+	out := logs.String()
+	for _, want := range []string{"bugstr: payload built", "bugstr: report delivered"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("debug log missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "nsec1abc") || strings.Contains(out, "secret") {
+		t.Errorf("debug log leaked report contents:\n%s", out)
+	}
+}
+
+func TestTruncateMessageKeepsHeadAndTail(t *testing.T) {
+	msg := "query failed: " + strings.Repeat("x", 500) + " duplicate key"
+	got := truncateMessage(msg, 80)
+	if len(got) > 80 {
+		t.Fatalf("truncated message is %d bytes, want <= 80", len(got))
+	}
+	if !strings.HasPrefix(got, "query failed") || !strings.HasSuffix(got, "duplicate key") {
+		t.Fatalf("head or tail lost: %q", got)
+	}
+	if !strings.Contains(got, "bytes truncated") {
+		t.Fatalf("missing truncation marker: %q", got)
+	}
+	if truncateMessage(msg, 0) != msg {
+		t.Fatal("zero max should leave the message unchanged")
+	}
+	if got := truncateMessage(strings.Repeat("é", 100), 60); !utf8.ValidString(got) {
+		t.Fatalf("truncation split a rune: %q", got)
+	}
+}
+
+func TestRelaysForEnvironment(t *testing.T) {
+	c := &client{config: Config{
+		Relays:              []string{"wss://public"},
+		RelaysByEnvironment: map[string][]string{"production": {"wss://paid"}},
+	}}
+	if got := c.relaysFor("production"); len(got) != 1 || got[0] != "wss://paid" {
+		t.Fatalf("production: got %v", got)
+	}
+	if got := c.relaysFor("staging"); len(got) != 1 || got[0] != "wss://public" {
+		t.Fatalf("staging: got %v", got)
+	}
+	if got := (&client{}).relaysFor("staging"); len(got) != len(defaultRelays) {
+		t.Fatalf("unconfigured: got %v", got)
+	}
+
+	var current []string
+	c.config.RelayProvider = func() []string { return current }
+	if got := c.relaysFor("production"); len(got) != 1 || got[0] != "wss://paid" {
+		t.Fatalf("empty provider should fall back: got %v", got)
+	}
+	current = []string{"wss://dynamic"}
+	if got := c.relaysFor("production"); len(got) != 1 || got[0] != "wss://dynamic" {
+		t.Fatalf("provider: got %v", got)
+	}
+}
+
+func TestCaptureStackIncludesCaller(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var reported *Payload
+	c := &client{config: Config{Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+		reported = p
+		return nil
+	})}}
+	active.Store(c)
+
+	CaptureStack("how did we get here", LevelInfo)
+	Flush(context.Background())
+	if reported == nil || reported.Level != LevelInfo || reported.Message != "how did we get here" {
+		t.Fatalf("unexpected report %+v", reported)
+	}
+	if !strings.Contains(reported.Stack, "TestCaptureStackIncludesCaller") {
+		t.Fatalf("stack missing caller:\n%s", reported.Stack)
+	}
+}
+
+func TestRandomPastTimestampSeeded(t *testing.T) {
+	t.Cleanup(func() { seedTimestampRand(cryptoSeed()) })
+
+	draw := func() []int64 {
+		seedTimestampRand(42)
+		var offsets []int64
+		for i := 0; i < 5; i++ {
+			now := time.Now().Unix()
+			ts := randomPastTimestamp()
+			if ts > now || ts < now-maxTimestampJitter {
+				t.Fatalf("timestamp %d outside [%d, %d]", ts, now-maxTimestampJitter, now)
+			}
+			offsets = append(offsets, now-ts)
+		}
+		return offsets
+	}
+	first, second := draw(), draw()
+	for i := range first {
+		// Allow for the clock ticking over a second between draws.
+		if d := first[i] - second[i]; d < -1 || d > 1 {
+			t.Fatalf("seeded offsets differ: %v vs %v", first, second)
+		}
+	}
+}
+
+func TestPayloadSDK(t *testing.T) {
+	payload := (&client{}).buildPayload(CaptureEvent{Message: "sdk"})
+	if payload.SDK != "bugstr-go/devel" {
+		t.Fatalf("SDK = %q, want bugstr-go/devel in tests", payload.SDK)
+	}
+}
+
+func TestAutoDetectRelease(t *testing.T) {
+	orig := appBuild
+	t.Cleanup(func() { appBuild = orig })
+	appBuild = func() (string, *BuildInfo) { return "v1.2.3", &BuildInfo{Revision: "abc123", Modified: true} }
+
+	p := (&client{}).buildPayload(CaptureEvent{Message: "auto"})
+	if p.Release != "v1.2.3" || p.Build == nil || p.Build.Revision != "abc123" || !p.Build.Modified {
+		t.Fatalf("Release = %q, Build = %+v", p.Release, p.Build)
+	}
+	p = (&client{config: Config{Release: "2.0"}}).buildPayload(CaptureEvent{Message: "explicit"})
+	if p.Release != "2.0" || p.Build != nil {
+		t.Fatalf("explicit Release should win: %q, %+v", p.Release, p.Build)
+	}
+	p = (&client{config: Config{AutoDetectRelease: Bool(false)}}).buildPayload(CaptureEvent{Message: "off"})
+	if p.Release != "" || p.Build != nil {
+		t.Fatalf("disabled: %q, %+v", p.Release, p.Build)
+	}
+}
+
+func TestCompressionModes(t *testing.T) {
+	repetitive := []byte(`{"message":"` + strings.Repeat("a", 600) + `"}`)
+
+	gzipped := (&client{}).encodeContent(repetitive)
+	if gzipped != string(repetitive) {
+		t.Fatal("default gzip mode should leave content under 1KB as is")
+	}
+	if got := (&client{config: Config{Compression: CompressionNone}}).encodeContent(repetitive); got != string(repetitive) {
+		t.Fatal("none should never compress")
+	}
+	auto := &client{config: Config{Compression: CompressionAuto}}
+	if got := auto.encodeContent(repetitive); !strings.Contains(got, `"compression":"gzip"`) {
+		t.Fatalf("auto should compress repetitive content under 1KB, got %q", got)
+	}
+	tiny := []byte(`{"message":"x"}`)
+	if got := auto.encodeContent(tiny); got != string(tiny) {
+		t.Fatalf("auto should keep content that compression would grow, got %q", got)
+	}
+}
+
+func TestRecoverAndExit(t *testing.T) {
+	if os.Getenv("BUGSTR_TEST_RECOVER_AND_EXIT") == "1" {
+		defer RecoverAndExit(3)
+		panic("before init")
+	}
+	if runtime.GOOS == "js" || runtime.GOOS == "wasip1" {
+		t.Skip("needs a subprocess")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRecoverAndExit$")
+	cmd.Env = append(os.Environ(), "BUGSTR_TEST_RECOVER_AND_EXIT=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("exit = %v, want code 3", err)
+	}
+	if got := stderr.String(); !strings.Contains(got, "panic: before init\n") || strings.Contains(got, "reported by bugstr") {
+		t.Fatalf("stderr = %q, want the panic without claiming a report", got)
+	}
+}
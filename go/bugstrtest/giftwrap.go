@@ -0,0 +1,57 @@
+package bugstrtest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// OpenGiftWrap decrypts a kind 1059 gift wrap addressed to recipientPrivkey
+// and returns the inner kind 14 rumor. The seal signature is verified and
+// the rumor must be authored by the seal's signer, per NIP-59.
+//
+// The rumor's Content is the report as published; large reports are a
+// compressed envelope rather than plain payload JSON.
+func OpenGiftWrap(giftWrap *nostr.Event, recipientPrivkey string) (*nostr.Event, error) {
+	if giftWrap.Kind != 1059 {
+		return nil, fmt.Errorf("bugstrtest: expected kind 1059, got %d", giftWrap.Kind)
+	}
+
+	seal, err := decryptEvent(giftWrap, recipientPrivkey)
+	if err != nil {
+		return nil, fmt.Errorf("bugstrtest: open gift wrap: %w", err)
+	}
+	if seal.Kind != 13 {
+		return nil, fmt.Errorf("bugstrtest: expected kind 13 seal, got %d", seal.Kind)
+	}
+	if ok, _ := seal.CheckSignature(); !ok {
+		return nil, fmt.Errorf("bugstrtest: invalid seal signature")
+	}
+
+	rumor, err := decryptEvent(seal, recipientPrivkey)
+	if err != nil {
+		return nil, fmt.Errorf("bugstrtest: open seal: %w", err)
+	}
+	if rumor.PubKey != seal.PubKey {
+		return nil, fmt.Errorf("bugstrtest: rumor pubkey does not match seal signer")
+	}
+	return rumor, nil
+}
+
+func decryptEvent(event *nostr.Event, recipientPrivkey string) (*nostr.Event, error) {
+	key, err := nip44.GenerateConversationKey(event.PubKey, recipientPrivkey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := nip44.Decrypt(event.Content, key)
+	if err != nil {
+		return nil, err
+	}
+	var inner nostr.Event
+	if err := json.Unmarshal([]byte(plaintext), &inner); err != nil {
+		return nil, err
+	}
+	return &inner, nil
+}
@@ -0,0 +1,208 @@
+// Package bugstrtest provides helpers for testing bugstr integrations
+// end-to-end without touching public relays.
+//
+// Point bugstr at an in-process relay, capture, then fetch and open the
+// resulting gift wrap with the developer key:
+//
+//	relay := bugstrtest.NewRelay()
+//	defer relay.Close()
+//
+//	developerKey := nostr.GeneratePrivateKey()
+//	developerPubkey, _ := nostr.GetPublicKey(developerKey)
+//	bugstr.Init(bugstr.Config{
+//	    DeveloperPubkey: developerPubkey,
+//	    Relays:          []string{relay.URL},
+//	})
+//
+//	bugstr.CaptureMessage("boom")
+//	event, _ := relay.WaitForEvent(ctx, nostr.Filter{Kinds: []int{1059}})
+//	rumor, _ := bugstrtest.OpenGiftWrap(event, developerKey)
+package bugstrtest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay is a minimal in-memory NIP-01 relay served over a local websocket.
+//
+// It accepts every EVENT, answers REQ with stored matches followed by EOSE,
+// and streams later matches to open subscriptions. There is no persistence,
+// auth, or signature verification beyond what go-nostr clients already do.
+type Relay struct {
+	// URL is the ws:// address to pass in Config.Relays.
+	URL string
+
+	server *httptest.Server
+
+	mu      sync.Mutex
+	events  []*nostr.Event
+	subs    map[*relayConn]map[string]nostr.Filters
+	changed chan struct{}
+	discard bool
+}
+
+// relayConn serializes writes to one client connection.
+type relayConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *relayConn) write(env nostr.Envelope) {
+	msg, err := env.MarshalJSON()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wsutil.WriteServerText(c.conn, msg)
+}
+
+// NewRelay starts a relay listening on a random local port.
+// Call Close when done.
+func NewRelay() *Relay {
+	r := &Relay{
+		subs:    make(map[*relayConn]map[string]nostr.Filters),
+		changed: make(chan struct{}),
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	r.URL = "ws" + strings.TrimPrefix(r.server.URL, "http")
+	return r
+}
+
+// Close shuts down the relay and disconnects all clients.
+func (r *Relay) Close() {
+	r.server.CloseClientConnections()
+	r.server.Close()
+}
+
+// DiscardEvents makes the relay acknowledge later events with OK but not
+// store or forward them, like relays that accept then silently drop.
+func (r *Relay) DiscardEvents(discard bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.discard = discard
+}
+
+// Events returns a snapshot of every event the relay has accepted.
+func (r *Relay) Events() []*nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*nostr.Event(nil), r.events...)
+}
+
+// WaitForEvent blocks until an event matching filter has been published,
+// or ctx is done.
+func (r *Relay) WaitForEvent(ctx context.Context, filter nostr.Filter) (*nostr.Event, error) {
+	for {
+		r.mu.Lock()
+		for _, event := range r.events {
+			if filter.Matches(event) {
+				r.mu.Unlock()
+				return event, nil
+			}
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (r *Relay) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, _, _, err := ws.UpgradeHTTP(req, w)
+	if err != nil {
+		return
+	}
+	client := &relayConn{conn: conn}
+	defer func() {
+		r.mu.Lock()
+		delete(r.subs, client)
+		r.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		msg, err := wsutil.ReadClientText(conn)
+		if err != nil {
+			return
+		}
+		switch env := nostr.ParseMessage(msg).(type) {
+		case *nostr.EventEnvelope:
+			r.handleEvent(client, &env.Event)
+		case *nostr.ReqEnvelope:
+			r.handleReq(client, env)
+		case *nostr.CloseEnvelope:
+			r.mu.Lock()
+			delete(r.subs[client], string(*env))
+			r.mu.Unlock()
+		}
+	}
+}
+
+func (r *Relay) handleEvent(client *relayConn, event *nostr.Event) {
+	if ok, _ := event.CheckSignature(); !ok {
+		client.write(&nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: "invalid: bad signature"})
+		return
+	}
+
+	r.mu.Lock()
+	if r.discard {
+		r.mu.Unlock()
+		client.write(&nostr.OKEnvelope{EventID: event.ID, OK: true})
+		return
+	}
+	r.events = append(r.events, event)
+	close(r.changed)
+	r.changed = make(chan struct{})
+	var listeners []*relayConn
+	var subIDs []string
+	for conn, subs := range r.subs {
+		for id, filters := range subs {
+			if filters.Match(event) {
+				listeners = append(listeners, conn)
+				subIDs = append(subIDs, id)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	client.write(&nostr.OKEnvelope{EventID: event.ID, OK: true})
+	for i, conn := range listeners {
+		id := subIDs[i]
+		conn.write(&nostr.EventEnvelope{SubscriptionID: &id, Event: *event})
+	}
+}
+
+func (r *Relay) handleReq(client *relayConn, req *nostr.ReqEnvelope) {
+	r.mu.Lock()
+	if r.subs[client] == nil {
+		r.subs[client] = make(map[string]nostr.Filters)
+	}
+	r.subs[client][req.SubscriptionID] = req.Filters
+	var matches []*nostr.Event
+	for _, event := range r.events {
+		if req.Filters.Match(event) {
+			matches = append(matches, event)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, event := range matches {
+		client.write(&nostr.EventEnvelope{SubscriptionID: &req.SubscriptionID, Event: *event})
+	}
+	eose := nostr.EOSEEnvelope(req.SubscriptionID)
+	client.write(&eose)
+}
@@ -0,0 +1,138 @@
+package bugstr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coalescer holds duplicates of recently sent reports until their
+// Config.CoalesceWindow closes.
+type coalescer struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*coalescedReport
+}
+
+// coalescedReport tracks the duplicates of one fingerprint in a window.
+type coalescedReport struct {
+	latest *Payload
+	count  int
+	timer  *time.Timer
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	if window <= 0 {
+		return nil
+	}
+	return &coalescer{window: window, pending: make(map[string]*coalescedReport)}
+}
+
+// coalesce reports whether payload duplicates a report sent within the
+// current window and was absorbed into its pending summary. The first
+// report for a fingerprint is never absorbed; it opens the window. Fatal
+// reports are never coalesced.
+func (c *client) coalesce(payload *Payload) bool {
+	co := c.coalescer
+	if co == nil || payload.Level == LevelFatal {
+		return false
+	}
+	fp := payload.Fingerprint
+	if fp == "" {
+		fp = fingerprint(payload)
+	}
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if r, ok := co.pending[fp]; ok {
+		r.latest = payload
+		r.count++
+		return true
+	}
+	co.pending[fp] = &coalescedReport{
+		timer: time.AfterFunc(co.window, func() { c.releaseCoalesced(fp) }),
+	}
+	return false
+}
+
+// releaseCoalesced closes the window for fp, sending the latest duplicate
+// with its OccurrenceCount if there were any.
+func (c *client) releaseCoalesced(fp string) {
+	co := c.coalescer
+	co.mu.Lock()
+	r := co.pending[fp]
+	delete(co.pending, fp)
+	co.mu.Unlock()
+	if r != nil && r.count > 0 {
+		r.latest.OccurrenceCount = r.count
+		c.dispatch(r.latest, nil)
+	}
+}
+
+// flushCoalesced closes every open window now, e.g. before shutdown.
+func (c *client) flushCoalesced() {
+	co := c.coalescer
+	if co == nil {
+		return
+	}
+	co.mu.Lock()
+	pending := co.pending
+	co.pending = make(map[string]*coalescedReport)
+	co.mu.Unlock()
+	for _, r := range pending {
+		r.timer.Stop()
+		if r.count > 0 {
+			r.latest.OccurrenceCount = r.count
+			c.dispatch(r.latest, nil)
+		}
+	}
+}
+
+// fingerprint groups reports of the same problem: same type, message, and
+// innermost stack frame.
+func fingerprint(payload *Payload) string {
+	h := sha256.New()
+	h.Write([]byte(payload.Type))
+	h.Write([]byte{0})
+	h.Write([]byte(payload.Message))
+	h.Write([]byte{0})
+	h.Write([]byte(topFrame(payload.Stack)))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// customFingerprint hashes caller-supplied fingerprint components.
+func customFingerprint(components []string) string {
+	h := sha256.New()
+	for _, component := range components {
+		h.Write([]byte(component))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// topFrame returns the innermost frame of a Go stack trace as its function
+// name and file:line, or "" if there is none. Argument words and the PC
+// offset are dropped, since they vary between calls to the same code.
+func topFrame(stack string) string {
+	lines := strings.SplitN(stack, "\n", 4)
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "goroutine ") {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	fn := lines[0]
+	if i := strings.LastIndexByte(fn, '('); i > 0 {
+		fn = fn[:i]
+	}
+	if len(lines) < 2 {
+		return fn
+	}
+	file := strings.TrimSpace(lines[1])
+	if i := strings.LastIndex(file, " +0x"); i >= 0 {
+		file = file[:i]
+	}
+	return fn + "\n" + file
+}
@@ -0,0 +1,119 @@
+package bugstr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCoalesceWindowCountsDuplicates(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var mu sync.Mutex
+	var sent []*Payload
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		CoalesceWindow:  time.Hour,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			mu.Lock()
+			sent = append(sent, p)
+			mu.Unlock()
+			return nil
+		}),
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		CaptureExceptionWithStack(errors.New("db down"), "goroutine 1 [running]:\nmain.f()\n\t/app/main.go:1 +0x1\n")
+	}
+	CaptureExceptionWithStack(errors.New("other"), "goroutine 1 [running]:\nmain.f()\n\t/app/main.go:1 +0x1\n")
+	if err := Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	counts := map[string][]int{}
+	for _, p := range sent {
+		counts[p.Message] = append(counts[p.Message], p.OccurrenceCount)
+	}
+	if got := counts["db down"]; len(got) != 2 || got[0]+got[1] != 3 {
+		t.Fatalf("db down: want first report plus one summary of 3, got counts %v", got)
+	}
+	if got := counts["other"]; len(got) != 1 || got[0] != 0 {
+		t.Fatalf("other: want a single uncoalesced report, got counts %v", got)
+	}
+}
+
+func TestCoalesceNeverAbsorbsFatal(t *testing.T) {
+	c := &client{coalescer: newCoalescer(time.Hour)}
+	for i := 0; i < 3; i++ {
+		if c.coalesce(&Payload{Message: "crash", Level: LevelFatal}) {
+			t.Fatalf("fatal report %d was coalesced", i)
+		}
+	}
+}
+
+func TestCaptureWithFingerprintGroups(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var mu sync.Mutex
+	var sent []*Payload
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		CoalesceWindow:  time.Hour,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			mu.Lock()
+			sent = append(sent, p)
+			mu.Unlock()
+			return nil
+		}),
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	for _, id := range []string{"a1", "b2", "c3"} {
+		CaptureWithFingerprint(errors.New("database error: "+id), []string{"database-error"})
+	}
+	if err := Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 || sent[1].OccurrenceCount != 2 {
+		t.Fatalf("want first report plus a summary of 2, got %d reports", len(sent))
+	}
+	if sent[0].Fingerprint != customFingerprint([]string{"database-error"}) {
+		t.Fatalf("Fingerprint = %q", sent[0].Fingerprint)
+	}
+	if auto := (&client{}).buildPayload(CaptureEvent{Message: "auto"}); auto.Fingerprint != fingerprint(auto) {
+		t.Fatalf("automatic Fingerprint = %q", auto.Fingerprint)
+	}
+}
+
+func TestFingerprintIgnoresArgumentWords(t *testing.T) {
+	stack := func(args string) string {
+		return "goroutine 7 [running]:\nmain.handle(" + args + ")\n\t/app/main.go:42 +0x1d\nmain.main()\n\t/app/main.go:10 +0x25\n"
+	}
+	a := &Payload{Type: "panic", Message: "boom", Stack: stack("0xc000123400, 0x1")}
+	b := &Payload{Type: "panic", Message: "boom", Stack: stack("0xc0004a8000, 0x1")}
+	if fingerprint(a) != fingerprint(b) {
+		t.Fatal("stacks differing only in argument words should share a fingerprint")
+	}
+
+	c := &Payload{Type: "panic", Message: "boom", Stack: strings.Replace(stack("0x1"), "main.go:42", "main.go:43", 1)}
+	if fingerprint(a) == fingerprint(c) {
+		t.Fatal("different lines should not share a fingerprint")
+	}
+}
@@ -0,0 +1,66 @@
+package bugstr
+
+import "fmt"
+
+// Consent is a user's remembered answer to the ConfirmSend prompt.
+type Consent string
+
+// Consent choices.
+const (
+	// ConsentAsk calls Config.ConfirmSend for each report. The default.
+	ConsentAsk Consent = "ask"
+	// ConsentAlways sends reports without prompting.
+	ConsentAlways Consent = "always"
+	// ConsentNever drops reports without prompting.
+	ConsentNever Consent = "never"
+)
+
+// consentKey is the Store key holding the Consent choice.
+const consentKey = "consent"
+
+// SetConsent remembers the user's choice in Config.Store, e.g. from an
+// "always send" checkbox in the ConfirmSend dialog, so later reports are
+// sent or dropped without prompting. It applies only when ConfirmSend is
+// set, and is read back by Init on the next run.
+func SetConsent(choice Consent) error {
+	switch choice {
+	case ConsentAsk, ConsentAlways, ConsentNever:
+	default:
+		return fmt.Errorf("bugstr: unknown consent %q", choice)
+	}
+	c := currentClient()
+	if c == nil {
+		return ErrNotInitialized
+	}
+	c.store().Set(consentKey, []byte(choice))
+	c.consent.Store(&choice)
+	return nil
+}
+
+// CurrentConsent returns the remembered consent choice, ConsentAsk if none
+// is stored or before Init.
+func CurrentConsent() Consent {
+	if c := currentClient(); c != nil {
+		return c.currentConsent()
+	}
+	return ConsentAsk
+}
+
+func (c *client) currentConsent() Consent {
+	if choice := c.consent.Load(); choice != nil {
+		return *choice
+	}
+	return ConsentAsk
+}
+
+// loadConsent reads the stored consent choice into c.
+func (c *client) loadConsent() {
+	val, ok := c.store().Get(consentKey)
+	if !ok {
+		return
+	}
+	switch choice := Consent(val); choice {
+	case ConsentAlways, ConsentNever:
+		c.consent.Store(&choice)
+	}
+}
@@ -0,0 +1,69 @@
+package bugstr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestConsentPersists(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	if err := SetConsent(ConsentAlways); !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("SetConsent before Init = %v", err)
+	}
+
+	store := &MemoryStore{}
+	var prompts, sent int
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	initialize := func() {
+		t.Helper()
+		resetForTest(t)
+		if err := Init(Config{
+			DeveloperPubkey: developerPubkey,
+			Store:           store,
+			ConfirmSend:     func(Summary) bool { prompts++; return true },
+			Transport: TransportFunc(func(context.Context, *Payload) error {
+				sent++
+				return nil
+			}),
+		}); err != nil {
+			t.Fatalf("Init: %v", err)
+		}
+	}
+	capture := func() {
+		t.Helper()
+		if err := CaptureExceptionSync(context.Background(), errors.New("crash")); err != nil {
+			t.Fatalf("CaptureExceptionSync: %v", err)
+		}
+	}
+
+	initialize()
+	capture()
+	if prompts != 1 || sent != 1 || CurrentConsent() != ConsentAsk {
+		t.Fatalf("ask: prompts=%d sent=%d consent=%s", prompts, sent, CurrentConsent())
+	}
+	if err := SetConsent("sometimes"); err == nil {
+		t.Fatal("unknown consent should be rejected")
+	}
+
+	if err := SetConsent(ConsentAlways); err != nil {
+		t.Fatal(err)
+	}
+	initialize()
+	capture()
+	if prompts != 1 || sent != 2 {
+		t.Fatalf("always, after restart: prompts=%d sent=%d", prompts, sent)
+	}
+
+	if err := SetConsent(ConsentNever); err != nil {
+		t.Fatal(err)
+	}
+	capture()
+	if prompts != 1 || sent != 2 {
+		t.Fatalf("never: prompts=%d sent=%d", prompts, sent)
+	}
+}
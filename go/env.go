@@ -0,0 +1,138 @@
+package bugstr
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables read by ConfigFromEnv and MergeEnv.
+const (
+	EnvDeveloperPubkey        = "BUGSTR_DEVELOPER_PUBKEY"
+	EnvRelays                 = "BUGSTR_RELAYS" // comma-separated
+	EnvEnvironment            = "BUGSTR_ENVIRONMENT"
+	EnvRelease                = "BUGSTR_RELEASE"
+	EnvMirrorWebhook          = "BUGSTR_MIRROR_WEBHOOK"
+	EnvIncludeStack           = "BUGSTR_INCLUDE_STACK"
+	EnvTrimStackPaths         = "BUGSTR_TRIM_STACK_PATHS"
+	EnvMaxStackFrames         = "BUGSTR_MAX_STACK_FRAMES"
+	EnvPublicEnvironmentTag   = "BUGSTR_PUBLIC_ENVIRONMENT_TAG"
+	EnvCaptureGoroutineLabels = "BUGSTR_CAPTURE_GOROUTINE_LABELS"
+)
+
+// ConfigFromEnv builds a Config from BUGSTR_* environment variables,
+// so deployments can configure reporting without hardcoding the developer
+// pubkey in source:
+//
+//	cfg, err := bugstr.ConfigFromEnv()
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	bugstr.Init(cfg)
+//
+// Returns an error if a numeric or boolean variable can't be parsed.
+func ConfigFromEnv() (Config, error) {
+	return MergeEnv(Config{})
+}
+
+// MergeEnv fills unset fields of cfg from BUGSTR_* environment variables.
+// Values set explicitly in cfg win over the environment. Only the string,
+// relay list, bool, and int fields named by the Env* constants are read;
+// hooks, interfaces such as Signer and Transport, and every other field
+// are left as given.
+//
+// PublicEnvironmentTag and CaptureGoroutineLabels are plain bools, so a
+// false in cfg can't be told from unset: the environment can turn them on
+// but never off. Clear BUGSTR_PUBLIC_ENVIRONMENT_TAG, or set the field
+// after MergeEnv, to keep the public tag off regardless of deployment.
+//
+// Returns an error if a numeric or boolean variable can't be parsed.
+func MergeEnv(cfg Config) (Config, error) {
+	if cfg.DeveloperPubkey == "" {
+		cfg.DeveloperPubkey = os.Getenv(EnvDeveloperPubkey)
+	}
+	if len(cfg.Relays) == 0 {
+		cfg.Relays = splitList(os.Getenv(EnvRelays))
+	}
+	if cfg.Environment == "" {
+		cfg.Environment = os.Getenv(EnvEnvironment)
+	}
+	if cfg.Release == "" {
+		cfg.Release = os.Getenv(EnvRelease)
+	}
+	if cfg.MirrorWebhook == "" {
+		cfg.MirrorWebhook = os.Getenv(EnvMirrorWebhook)
+	}
+
+	var err error
+	if cfg.IncludeStack == nil {
+		if cfg.IncludeStack, err = envBoolPtr(EnvIncludeStack); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.TrimStackPaths == nil {
+		if cfg.TrimStackPaths, err = envBoolPtr(EnvTrimStackPaths); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.MaxStackFrames == 0 {
+		if cfg.MaxStackFrames, err = envInt(EnvMaxStackFrames); err != nil {
+			return cfg, err
+		}
+	}
+	if !cfg.PublicEnvironmentTag {
+		if cfg.PublicEnvironmentTag, err = envBool(EnvPublicEnvironmentTag); err != nil {
+			return cfg, err
+		}
+	}
+	if !cfg.CaptureGoroutineLabels {
+		if cfg.CaptureGoroutineLabels, err = envBool(EnvCaptureGoroutineLabels); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func envBool(name string) (bool, error) {
+	p, err := envBoolPtr(name)
+	if p == nil {
+		return false, err
+	}
+	return *p, err
+}
+
+func envBoolPtr(name string) (*bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("bugstr: invalid %s: %w", name, err)
+	}
+	return &b, nil
+}
+
+func envInt(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("bugstr: invalid %s: %w", name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,38 @@
+package bugstr
+
+import "testing"
+
+func TestMergeEnvExplicitValuesWin(t *testing.T) {
+	t.Setenv(EnvDeveloperPubkey, "npub1fromenv")
+	t.Setenv(EnvRelays, "wss://a.example, wss://b.example,")
+	t.Setenv(EnvEnvironment, "staging")
+	t.Setenv(EnvIncludeStack, "false")
+	t.Setenv(EnvMaxStackFrames, "20")
+
+	cfg, err := MergeEnv(Config{Environment: "production"})
+	if err != nil {
+		t.Fatalf("MergeEnv: %v", err)
+	}
+	if cfg.DeveloperPubkey != "npub1fromenv" {
+		t.Fatalf("DeveloperPubkey = %q", cfg.DeveloperPubkey)
+	}
+	if len(cfg.Relays) != 2 || cfg.Relays[1] != "wss://b.example" {
+		t.Fatalf("Relays = %v", cfg.Relays)
+	}
+	if cfg.Environment != "production" {
+		t.Fatalf("explicit Environment overridden: %q", cfg.Environment)
+	}
+	if cfg.IncludeStack == nil || *cfg.IncludeStack {
+		t.Fatalf("IncludeStack = %v", cfg.IncludeStack)
+	}
+	if cfg.MaxStackFrames != 20 {
+		t.Fatalf("MaxStackFrames = %d", cfg.MaxStackFrames)
+	}
+}
+
+func TestMergeEnvRejectsInvalidValues(t *testing.T) {
+	t.Setenv(EnvMaxStackFrames, "lots")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Fatal("expected error for non-numeric BUGSTR_MAX_STACK_FRAMES")
+	}
+}
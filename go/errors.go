@@ -0,0 +1,83 @@
+package bugstr
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Delivery errors. Errors returned by CaptureExceptionSync and passed to
+// Config.OnError wrap these, so callers can branch with errors.Is, e.g. to
+// retry later on ErrRateLimited but give up on ErrAuthRequired.
+var (
+	// ErrNotInitialized is returned when capturing before Init.
+	ErrNotInitialized = errors.New("bugstr: not initialized")
+
+	// ErrAllRelaysFailed means no relay accepted the report. It wraps each
+	// relay's failure, which may in turn wrap ErrRateLimited or
+	// ErrAuthRequired.
+	ErrAllRelaysFailed = errors.New("bugstr: all relays failed")
+
+	// ErrTooFewRelays means some relays accepted the report, but fewer
+	// than Config.MinRelaySuccesses. The report was delivered.
+	ErrTooFewRelays = errors.New("bugstr: too few relays accepted")
+
+	// ErrArchiveFailed is passed to Config.OnError when one or more
+	// Config.ArchiveRelays did not accept a report. It wraps each failure.
+	ErrArchiveFailed = errors.New("bugstr: archive relay failed")
+
+	// ErrRateLimited means a relay rejected the event with "rate-limited:".
+	ErrRateLimited = errors.New("bugstr: rate limited")
+
+	// ErrAuthRequired means a relay rejected the event with "auth-required:"
+	// (NIP-42).
+	ErrAuthRequired = errors.New("bugstr: auth required")
+
+	// ErrPaymentRequired means a relay rejected the event with
+	// "payment-required:", typically carrying a BOLT11 invoice to pay
+	// (see Config.PayInvoice).
+	ErrPaymentRequired = errors.New("bugstr: payment required")
+
+	// ErrBudgetExceeded means a report, or its upload to one relay, was
+	// dropped because sending it would exceed Config.UploadBudgetBytesPerHour.
+	ErrBudgetExceeded = errors.New("bugstr: upload budget exceeded")
+
+	// ErrNotPersisted means a relay accepted the event but did not return
+	// it when queried back (see Config.VerifyPersistence).
+	ErrNotPersisted = errors.New("bugstr: relay did not persist event")
+
+	// ErrEncryptionFailed means the report could not be sealed or gift
+	// wrapped, e.g. because Config.Signer refused or a panic was recovered
+	// while encrypting. The report is written to Config.FallbackWriter if
+	// set.
+	ErrEncryptionFailed = errors.New("bugstr: encryption failed")
+)
+
+// classifyRelayError wraps a relay publish error with the matching
+// sentinel based on the NIP-01 OK message prefix. go-nostr reports
+// rejections as "msg: <reason>".
+func classifyRelayError(err error) error {
+	reason := strings.TrimPrefix(err.Error(), "msg: ")
+	switch {
+	case strings.HasPrefix(reason, "rate-limited:"):
+		return fmt.Errorf("%w: %w", ErrRateLimited, err)
+	case strings.HasPrefix(reason, "auth-required:"):
+		return fmt.Errorf("%w: %w", ErrAuthRequired, err)
+	case strings.HasPrefix(reason, "payment-required:"):
+		return fmt.Errorf("%w: %w", ErrPaymentRequired, err)
+	default:
+		return err
+	}
+}
+
+var bolt11Pattern = regexp.MustCompile(`(?i)\bln(?:bc|tbs|tb|bcrt)[0-9a-z]+`)
+
+// paymentInvoice returns the BOLT11 invoice in a payment-required relay
+// error, or "" if there is none.
+func paymentInvoice(err error) string {
+	if !errors.Is(err, ErrPaymentRequired) {
+		return ""
+	}
+	return bolt11Pattern.FindString(err.Error())
+}
@@ -0,0 +1,55 @@
+package bugstr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassifyRelayError(t *testing.T) {
+	cases := []struct {
+		reason string
+		want   error
+	}{
+		{"msg: rate-limited: slow down", ErrRateLimited},
+		{"msg: auth-required: please authenticate", ErrAuthRequired},
+		{"msg: payment-required: lnbc10n1pjexample", ErrPaymentRequired},
+	}
+	for _, tc := range cases {
+		err := classifyRelayError(errors.New(tc.reason))
+		if !errors.Is(err, tc.want) {
+			t.Errorf("classifyRelayError(%q) = %v, want %v", tc.reason, err, tc.want)
+		}
+	}
+
+	plain := errors.New("msg: blocked: spam")
+	if got := classifyRelayError(plain); got != plain {
+		t.Errorf("unclassified error should pass through, got %v", got)
+	}
+}
+
+func TestAllRelaysFailedWrapsCauses(t *testing.T) {
+	err := fmt.Errorf("%w: %w", ErrAllRelaysFailed, errors.Join(
+		fmt.Errorf("wss://a: %w", classifyRelayError(errors.New("msg: rate-limited: later"))),
+		errors.New("wss://b: dial failed"),
+	))
+	if !errors.Is(err, ErrAllRelaysFailed) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected both ErrAllRelaysFailed and ErrRateLimited in %v", err)
+	}
+	if errors.Is(err, ErrAuthRequired) {
+		t.Fatalf("unexpected ErrAuthRequired in %v", err)
+	}
+}
+
+func TestPaymentInvoice(t *testing.T) {
+	err := classifyRelayError(errors.New("msg: payment-required: pay lnbc10n1pjexample to write"))
+	if got := paymentInvoice(err); got != "lnbc10n1pjexample" {
+		t.Fatalf("paymentInvoice = %q", got)
+	}
+	if got := paymentInvoice(classifyRelayError(errors.New("msg: payment-required: see https://relay.example/pay"))); got != "" {
+		t.Fatalf("no invoice should give empty string, got %q", got)
+	}
+	if got := paymentInvoice(errors.New("msg: blocked: lnbc10n1pjexample")); got != "" {
+		t.Fatalf("non-payment error should give empty string, got %q", got)
+	}
+}
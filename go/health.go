@@ -0,0 +1,81 @@
+package bugstr
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthStatus describes the state of crash reporting, for a diagnostics
+// or about screen. See Health.
+type HealthStatus struct {
+	// Initialized reports whether Init has succeeded.
+	Initialized bool
+	// Pending is the number of reports being delivered in the background.
+	Pending int
+	// LastSuccess is when a report was last delivered, or zero if none has
+	// been yet.
+	LastSuccess time.Time
+	// LastError is the most recent delivery error, or nil if there has
+	// been none. LastErrorTime is when it happened.
+	LastError     error
+	LastErrorTime time.Time
+	// BudgetDropped counts reports dropped by Config.UploadBudgetBytesPerHour.
+	BudgetDropped int
+	// MirrorFailed counts reports that Config.MirrorWebhook failed to
+	// receive. Mirror failures don't affect LastError.
+	MirrorFailed int
+}
+
+// IsInitialized reports whether Init has succeeded, i.e. whether reports
+// are being captured.
+func IsInitialized() bool {
+	return currentClient() != nil
+}
+
+// Health returns the current state of crash reporting.
+func Health() HealthStatus {
+	c := currentClient()
+	if c == nil {
+		return HealthStatus{}
+	}
+	return c.health.status()
+}
+
+// healthStats records delivery outcomes for Health.
+type healthStats struct {
+	pending       atomic.Int64
+	budgetDropped atomic.Int64
+	mirrorFailed  atomic.Int64
+
+	mu            sync.Mutex
+	lastSuccess   time.Time
+	lastError     error
+	lastErrorTime time.Time
+}
+
+// record notes the outcome of one delivery.
+func (h *healthStats) record(err error) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastError, h.lastErrorTime = err, now
+		return
+	}
+	h.lastSuccess = now
+}
+
+func (h *healthStats) status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HealthStatus{
+		Initialized:   true,
+		Pending:       int(h.pending.Load()),
+		LastSuccess:   h.lastSuccess,
+		LastError:     h.lastError,
+		LastErrorTime: h.lastErrorTime,
+		BudgetDropped: int(h.budgetDropped.Load()),
+		MirrorFailed:  int(h.mirrorFailed.Load()),
+	}
+}
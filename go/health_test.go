@@ -0,0 +1,48 @@
+package bugstr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestHealthTracksDeliveries(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	if IsInitialized() || Health().Initialized {
+		t.Fatal("should not be initialized before Init")
+	}
+
+	sendErr := errors.New("relay down")
+	fail := false
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			if fail {
+				return sendErr
+			}
+			return nil
+		}),
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if !IsInitialized() {
+		t.Fatal("should be initialized after Init")
+	}
+
+	CaptureExceptionSync(context.Background(), errors.New("ok"))
+	status := Health()
+	if status.LastSuccess.IsZero() || status.LastError != nil || status.Pending != 0 {
+		t.Fatalf("after success: %+v", status)
+	}
+
+	fail = true
+	CaptureExceptionSync(context.Background(), errors.New("fails"))
+	if status := Health(); status.LastError != sendErr || status.LastErrorTime.IsZero() {
+		t.Fatalf("after failure: %+v", status)
+	}
+}
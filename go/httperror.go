@@ -0,0 +1,114 @@
+package bugstr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// maxHTTPBodySize bounds how much of a response body CaptureHTTPError
+// attaches.
+const maxHTTPBodySize = 4 * 1024
+
+// scrubbedHeaders are never copied into reports.
+var scrubbedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+}
+
+// CaptureHTTPError reports a failed HTTP call with its context: method,
+// URL with query values redacted, request headers with credentials
+// scrubbed, status code, and the first 4KB of the response body. The
+// details go in Payload.Extra["http"] and are redacted like any extra data.
+// resp and err may each be nil:
+//
+//	resp, err := client.Do(req)
+//	if err != nil || resp.StatusCode >= 500 {
+//	    bugstr.CaptureHTTPError(req, resp, err)
+//	}
+//
+// Reading the body doesn't consume it: resp.Body still yields the full
+// body afterwards. A *url.Error, as returned by http.Client.Do, has its URL
+// redacted the same way before its text reaches the report.
+func CaptureHTTPError(req *http.Request, resp *http.Response, err error) {
+	details := map[string]interface{}{}
+	msg := "HTTP request failed"
+	if req != nil {
+		details["method"] = req.Method
+		if req.URL != nil {
+			details["url"] = redactQuery(req.URL)
+		}
+		if headers := scrubHeaders(req.Header); len(headers) > 0 {
+			details["request_headers"] = headers
+		}
+		msg = fmt.Sprintf("HTTP %s %s", req.Method, details["url"])
+	}
+	if resp != nil {
+		details["status"] = resp.StatusCode
+		msg += fmt.Sprintf(": %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		if body := peekBody(resp); body != "" {
+			details["response_body"] = body
+		}
+	}
+	if urlErr, ok := err.(*url.Error); ok {
+		redacted := *urlErr
+		if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+			redacted.URL = redactQuery(u)
+		} else {
+			redacted.URL = defaultRedactionReplacement
+		}
+		err = &redacted
+	}
+	Capture(CaptureEvent{Message: msg, Err: err, Extra: map[string]interface{}{"http": details}})
+}
+
+// redactQuery returns u without user info and with every query value
+// replaced, keeping the keys for context.
+func redactQuery(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	if u.RawQuery != "" {
+		// Built by hand so the replacement isn't escaped to %5Bredacted%5D.
+		var pairs []string
+		for key := range u.Query() {
+			pairs = append(pairs, url.QueryEscape(key)+"="+defaultRedactionReplacement)
+		}
+		sort.Strings(pairs)
+		redacted.RawQuery = strings.Join(pairs, "&")
+	}
+	return redacted.String()
+}
+
+// scrubHeaders flattens headers, dropping credential-bearing ones.
+func scrubHeaders(header http.Header) map[string]string {
+	out := make(map[string]string, len(header))
+	for key, values := range header {
+		if scrubbedHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// peekBody reads up to maxHTTPBodySize bytes of resp.Body and puts them
+// back in front of the rest, so the caller can still read the whole body.
+func peekBody(resp *http.Response) string {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return ""
+	}
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBodySize))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	return string(head)
+}
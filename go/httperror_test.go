@@ -0,0 +1,89 @@
+package bugstr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCaptureHTTPError(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var reported *Payload
+	active.Store(&client{config: Config{Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+		reported = p
+		return nil
+	})}})
+
+	req := httptest.NewRequest("POST", "https://user:pw@api.example/v1/pay?token=abc&amount=5", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept", "application/json")
+	body := strings.Repeat("e", maxHTTPBodySize+10)
+	resp := &http.Response{StatusCode: 502, Body: io.NopCloser(strings.NewReader(body))}
+
+	CaptureHTTPError(req, resp, errors.New("upstream"))
+	Flush(context.Background())
+
+	if reported == nil {
+		t.Fatal("no report")
+	}
+	if !strings.HasPrefix(reported.Message, "HTTP POST https://api.example/v1/pay?") || !strings.Contains(reported.Message, "502 Bad Gateway") {
+		t.Fatalf("unexpected message %q", reported.Message)
+	}
+	details := reported.Extra["http"].(map[string]interface{})
+	if u := details["url"].(string); strings.Contains(u, "abc") || strings.Contains(u, "pw") {
+		t.Fatalf("URL not redacted: %s", u)
+	}
+	headers := details["request_headers"].(map[string]interface{})
+	if _, ok := headers["Authorization"]; ok || headers["Accept"] != "application/json" {
+		t.Fatalf("headers not scrubbed correctly: %v", headers)
+	}
+	if got := details["response_body"].(string); len(got) != maxHTTPBodySize {
+		t.Fatalf("body should be capped at %d bytes, got %d", maxHTTPBodySize, len(got))
+	}
+	if rest, _ := io.ReadAll(resp.Body); string(rest) != body {
+		t.Fatal("caller should still be able to read the full body")
+	}
+}
+
+func TestCaptureHTTPErrorRedactsURLError(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var reported *Payload
+	active.Store(&client{config: Config{Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+		reported = p
+		return nil
+	})}})
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	req, err := http.NewRequest("GET", server.URL+"/v1?token=SECRET123", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = http.DefaultClient.Do(req)
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("err = %v, want a *url.Error", err)
+	}
+
+	CaptureHTTPError(req, nil, err)
+	Flush(context.Background())
+
+	if reported == nil {
+		t.Fatal("no report")
+	}
+	if strings.Contains(reported.Message, "SECRET123") || strings.Contains(reported.Message, "%5B") {
+		t.Fatalf("message leaks or escapes the query: %q", reported.Message)
+	}
+	if strings.Count(reported.Message, "token=[redacted]") != 2 {
+		t.Fatalf("message %q should show the redacted URL in both places", reported.Message)
+	}
+}
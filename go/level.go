@@ -0,0 +1,32 @@
+package bugstr
+
+// Level is the severity of a report.
+type Level string
+
+// Report severities, from least to most severe.
+const (
+	LevelDebug   Level = "debug"
+	LevelInfo    Level = "info"
+	LevelWarning Level = "warning"
+	LevelError   Level = "error"
+	LevelFatal   Level = "fatal"
+)
+
+// defaultLevel is used when a capture doesn't specify a level.
+const defaultLevel = LevelError
+
+// rank orders levels by severity. Unknown levels rank as LevelError.
+func (l Level) rank() int {
+	switch l {
+	case LevelDebug:
+		return 0
+	case LevelInfo:
+		return 1
+	case LevelWarning:
+		return 2
+	case LevelFatal:
+		return 4
+	default:
+		return 3
+	}
+}
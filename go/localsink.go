@@ -0,0 +1,54 @@
+package bugstr
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// localSinkMaxBytes is the size at which the Config.LocalSink file is
+// rotated to "<path>.1", replacing any previous rotation.
+const localSinkMaxBytes = 10 << 20
+
+// localSink appends reports as JSON lines to a file.
+type localSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newLocalSink(path string) *localSink {
+	if path == "" {
+		return nil
+	}
+	return &localSink{path: path}
+}
+
+// write appends payload to the sink file, rotating it first if it has
+// reached localSinkMaxBytes. A nil sink does nothing.
+func (s *localSink) write(payload *Payload) error {
+	if s == nil {
+		return nil
+	}
+	line, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info, err := os.Stat(s.path); err == nil && info.Size()+int64(len(line)) > localSinkMaxBytes {
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,64 @@
+package bugstr
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalSinkAppendsReports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crashes.jsonl")
+	c := &client{
+		config:    Config{Transport: TransportFunc(func(context.Context, *Payload) error { return nil })},
+		localSink: newLocalSink(path),
+	}
+	for _, msg := range []string{"first", "second"} {
+		if err := c.deliver(context.Background(), &Payload{Message: msg}); err != nil {
+			t.Fatalf("deliver: %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var p Payload
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, p.Message)
+	}
+	if strings.Join(got, ",") != "first,second" {
+		t.Fatalf("sink messages = %v", got)
+	}
+}
+
+func TestLocalSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crashes.jsonl")
+	sink := newLocalSink(path)
+	big := &Payload{Message: strings.Repeat("x", localSinkMaxBytes*2/3)}
+	for i := 0; i < 2; i++ {
+		if err := sink.write(big); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > localSinkMaxBytes {
+		t.Fatalf("current file is %d bytes, over the cap", info.Size())
+	}
+}
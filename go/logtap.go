@@ -0,0 +1,102 @@
+package bugstr
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// logTapLines is how many recent log lines LogTap keeps.
+	logTapLines = 100
+	// logTapLineSize bounds each kept line, so one huge log line can't
+	// dominate the report.
+	logTapLineSize = 1024
+)
+
+// logTapRing is the ring behind LogTap, or nil if LogTap was never called.
+var logTapRing atomic.Pointer[lineRing]
+
+// LogTap returns a writer that keeps the most recent log lines in memory
+// and attaches them, redacted, to every report as Payload.Logs. Add it as
+// an extra output of your logger to get the logs leading up to a crash:
+//
+//	log.SetOutput(io.MultiWriter(os.Stderr, bugstr.LogTap()))
+//
+// Every call returns the same writer. It is safe for concurrent use and
+// may be set up before Init.
+func LogTap() io.Writer {
+	if ring := logTapRing.Load(); ring != nil {
+		return ring
+	}
+	logTapRing.CompareAndSwap(nil, &lineRing{})
+	return logTapRing.Load()
+}
+
+// tappedLogs returns the lines kept by LogTap, oldest first, or nil.
+func tappedLogs() []string {
+	if ring := logTapRing.Load(); ring != nil {
+		return ring.snapshot()
+	}
+	return nil
+}
+
+// lineRing is an io.Writer keeping the last logTapLines complete lines.
+type lineRing struct {
+	mu      sync.Mutex
+	lines   [logTapLines]string
+	next    int
+	full    bool
+	partial []byte
+}
+
+// Write splits p into lines. A trailing incomplete line is held until the
+// rest of it arrives.
+func (r *lineRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.partial = appendBounded(r.partial, p)
+			break
+		}
+		line := appendBounded(r.partial, p[:i])
+		r.partial = r.partial[:0]
+		r.add(string(line))
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (r *lineRing) add(line string) {
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % logTapLines
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *lineRing) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		if r.next == 0 {
+			return nil
+		}
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	out := make([]string, 0, logTapLines)
+	out = append(out, r.lines[r.next:]...)
+	return append(out, r.lines[:r.next]...)
+}
+
+// appendBounded appends p to buf, dropping whatever exceeds logTapLineSize.
+func appendBounded(buf, p []byte) []byte {
+	if room := logTapLineSize - len(buf); len(p) > room {
+		p = p[:max(room, 0)]
+	}
+	return append(buf, p...)
+}
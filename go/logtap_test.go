@@ -0,0 +1,42 @@
+package bugstr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLineRingKeepsRecentLines(t *testing.T) {
+	r := &lineRing{}
+	for i := 0; i < logTapLines+5; i++ {
+		fmt.Fprintf(r, "line %d\n", i)
+	}
+	r.Write([]byte("partial"))
+
+	lines := r.snapshot()
+	if len(lines) != logTapLines {
+		t.Fatalf("got %d lines, want %d", len(lines), logTapLines)
+	}
+	if lines[0] != "line 5" || lines[len(lines)-1] != fmt.Sprintf("line %d", logTapLines+4) {
+		t.Fatalf("unexpected window %q .. %q", lines[0], lines[len(lines)-1])
+	}
+
+	r.Write([]byte(" done\n" + strings.Repeat("x", 2*logTapLineSize) + "\n"))
+	lines = r.snapshot()
+	if lines[len(lines)-2] != "partial done" {
+		t.Fatalf("partial line not joined: %q", lines[len(lines)-2])
+	}
+	if len(lines[len(lines)-1]) != logTapLineSize {
+		t.Fatalf("long line not bounded: %d bytes", len(lines[len(lines)-1]))
+	}
+}
+
+func TestLogTapAttachesRedactedLines(t *testing.T) {
+	t.Cleanup(func() { logTapRing.Store(nil) })
+
+	fmt.Fprintln(LogTap(), "paying lnbc1xyz")
+	payload := (&client{}).buildPayload(CaptureEvent{Message: "boom"})
+	if len(payload.Logs) != 1 || payload.Logs[0] != "paying [redacted]" {
+		t.Fatalf("unexpected logs %q", payload.Logs)
+	}
+}
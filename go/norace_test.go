@@ -0,0 +1,5 @@
+//go:build !race
+
+package bugstr
+
+const raceEnabled = false
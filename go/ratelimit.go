@@ -0,0 +1,147 @@
+package bugstr
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// relayPacer spaces publishes to each relay about interval apart, so a
+// burst of reports doesn't trip a strict relay's rate limit. Each spacing
+// is jittered by up to ±10% so many clients don't line up on the same
+// boundaries.
+type relayPacer struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newRelayPacer(interval time.Duration) *relayPacer {
+	if interval <= 0 {
+		return nil
+	}
+	return &relayPacer{interval: interval, next: make(map[string]time.Time)}
+}
+
+// wait blocks until the next publish slot for relayURL, or until ctx is
+// done. An urgent ctx (see withUrgent) publishes immediately; publishes
+// already queued keep their slots, and later ones queue behind both. If
+// ctx is done first, the slot is given back unless a later publish has
+// already queued behind it. A nil pacer never waits.
+func (p *relayPacer) wait(ctx context.Context, relayURL string) error {
+	if p == nil {
+		return nil
+	}
+	now := time.Now()
+	p.mu.Lock()
+	// Relays whose next slot has passed no longer hold anyone back.
+	for url, next := range p.next {
+		if next.Before(now) {
+			delete(p.next, url)
+		}
+	}
+	prev, queued := p.next[relayURL]
+	slot := prev
+	if !queued || isUrgent(ctx) {
+		slot = now
+	}
+	end := slot.Add(p.jittered())
+	if queued && prev.After(end) {
+		// An urgent publish must not pull queued slots earlier.
+		end = prev
+	}
+	p.next[relayURL] = end
+	p.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		if p.next[relayURL].Equal(end) {
+			p.next[relayURL] = prev
+		}
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// jittered returns the pacing interval scaled by a random factor in
+// [0.9, 1.1).
+func (p *relayPacer) jittered() time.Duration {
+	return time.Duration(float64(p.interval) * (0.9 + 0.2*rand.Float64()))
+}
+
+// uploadBudget is a leaky bucket of upload bytes: it holds up to capacity
+// bytes and drains continuously at capacity per hour.
+type uploadBudget struct {
+	capacity float64
+	// now is the clock the bucket drains by; tests replace it.
+	now func() time.Time
+
+	mu      sync.Mutex
+	level   float64
+	updated time.Time
+}
+
+func newUploadBudget(bytesPerHour int) *uploadBudget {
+	if bytesPerHour <= 0 {
+		return nil
+	}
+	return &uploadBudget{capacity: float64(bytesPerHour), now: time.Now}
+}
+
+// fits reports whether n more bytes fit in the budget without charging
+// them. Urgent sends always fit. A nil budget fits everything.
+func (b *uploadBudget) fits(ctx context.Context, n int) bool {
+	if b == nil || isUrgent(ctx) {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drain(b.now())
+	return b.level+float64(n) <= b.capacity
+}
+
+// charge adds n bytes that were already sent, even past capacity. A nil
+// budget ignores it.
+func (b *uploadBudget) charge(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drain(b.now())
+	b.level += float64(n)
+}
+
+// drain empties the bucket by the bytes that leaked out since the last
+// update. b.mu must be held.
+func (b *uploadBudget) drain(now time.Time) {
+	if !b.updated.IsZero() {
+		drained := now.Sub(b.updated).Hours() * b.capacity
+		b.level = max(b.level-drained, 0)
+	}
+	b.updated = now
+}
+
+type urgentKey struct{}
+
+// withUrgent returns a context whose sends skip the relay pacer's queue
+// and the upload budget. Fatal reports are sent this way.
+func withUrgent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, urgentKey{}, true)
+}
+
+func isUrgent(ctx context.Context) bool {
+	urgent, _ := ctx.Value(urgentKey{}).(bool)
+	return urgent
+}
@@ -0,0 +1,178 @@
+package bugstr
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRelayPacerSpacesPublishes(t *testing.T) {
+	p := newRelayPacer(50 * time.Millisecond)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := p.wait(ctx, "wss://a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two spacings of 50ms, each jittered by at most -10%.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("three publishes took %v, want >= 90ms", elapsed)
+	}
+
+	start = time.Now()
+	if err := p.wait(ctx, "wss://b"); err != nil || time.Since(start) > 20*time.Millisecond {
+		t.Fatalf("another relay should not wait: err=%v after %v", err, time.Since(start))
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := p.wait(cancelled, "wss://a"); err == nil {
+		t.Fatal("wait should give up when ctx is done")
+	}
+
+	if err := (*relayPacer)(nil).wait(ctx, "wss://a"); err != nil {
+		t.Fatalf("nil pacer: %v", err)
+	}
+}
+
+func TestRelayPacerPrunesPassedSlots(t *testing.T) {
+	p := newRelayPacer(20 * time.Millisecond)
+	ctx := context.Background()
+	for _, url := range []string{"wss://a", "wss://b"} {
+		if err := p.wait(ctx, url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(p.next); n != 2 {
+		t.Fatalf("tracking %d relays, want 2", n)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := p.wait(ctx, "wss://c"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.next); n != 1 {
+		t.Fatalf("tracking %d relays after the interval, want 1", n)
+	}
+}
+
+func TestRelayPacerReturnsCancelledSlot(t *testing.T) {
+	p := newRelayPacer(time.Hour)
+	ctx := context.Background()
+	if err := p.wait(ctx, "wss://a"); err != nil {
+		t.Fatal(err)
+	}
+	held := p.next["wss://a"]
+
+	cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := p.wait(cancelled, "wss://a"); err == nil {
+		t.Fatal("wait should give up when ctx is done")
+	}
+	if got := p.next["wss://a"]; !got.Equal(held) {
+		t.Fatalf("next slot = %v, want the cancelled slot given back (%v)", got, held)
+	}
+}
+
+func TestUrgentPublishKeepsQueuedSlots(t *testing.T) {
+	p := newRelayPacer(40 * time.Millisecond)
+	ctx := context.Background()
+	if err := p.wait(ctx, "wss://a"); err != nil {
+		t.Fatal(err)
+	}
+	// Two publishes queue behind the first.
+	queued := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { queued <- p.wait(ctx, "wss://a") }()
+	}
+	for {
+		p.mu.Lock()
+		next := p.next["wss://a"]
+		p.mu.Unlock()
+		if time.Until(next) > 80*time.Millisecond {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	p.mu.Lock()
+	before := p.next["wss://a"]
+	p.mu.Unlock()
+	start := time.Now()
+	if err := p.wait(withUrgent(ctx), "wss://a"); err != nil || time.Since(start) > 20*time.Millisecond {
+		t.Fatalf("urgent publish should not wait: err=%v after %v", err, time.Since(start))
+	}
+	p.mu.Lock()
+	after := p.next["wss://a"]
+	p.mu.Unlock()
+	if after.Before(before) {
+		t.Fatalf("urgent publish moved the next slot earlier, from %v to %v", before, after)
+	}
+
+	// A later publish still queues behind both waiters.
+	if err := p.wait(ctx, "wss://a"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Now().Before(before) {
+		t.Fatal("a later publish ran before the queued ones' slots")
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-queued; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUploadBudgetDropsOverLimit(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	b := newUploadBudget(1000)
+	b.now = func() time.Time { return now }
+	if !b.fits(ctx, 600) {
+		t.Fatal("first 600 bytes should fit")
+	}
+	b.charge(600)
+	if b.fits(ctx, 600) {
+		t.Fatal("1200 bytes should exceed a 1000 byte budget")
+	}
+	if !b.fits(ctx, 400) || b.fits(ctx, 401) {
+		t.Fatal("fits should report the remaining 400 bytes")
+	}
+	b.charge(400)
+	if b.fits(ctx, 1) {
+		t.Fatal("a full budget should not fit more")
+	}
+
+	// An hour later the bucket has fully drained.
+	now = now.Add(time.Hour)
+	if !b.fits(ctx, 1000) {
+		t.Fatal("budget should refill over the hour")
+	}
+	if !(*uploadBudget)(nil).fits(ctx, 1<<30) {
+		t.Fatal("nil budget should fit everything")
+	}
+	(*uploadBudget)(nil).charge(1)
+}
+
+func TestUrgentSkipsPacingAndBudget(t *testing.T) {
+	ctx := context.Background()
+	p := newRelayPacer(time.Hour)
+	if err := p.wait(ctx, "wss://a"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := p.wait(withUrgent(ctx), "wss://a"); err != nil || time.Since(start) > 20*time.Millisecond {
+		t.Fatalf("urgent publish should not wait: err=%v after %v", err, time.Since(start))
+	}
+
+	b := newUploadBudget(1000)
+	if !b.fits(withUrgent(ctx), 1500) {
+		t.Fatal("urgent sends should bypass the budget")
+	}
+	b.charge(1500)
+	if b.fits(ctx, 1) {
+		t.Fatal("urgent sends should still be charged")
+	}
+}
@@ -0,0 +1,138 @@
+package bugstr
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// defaultRedactionReplacement replaces redacted matches unless
+// Config.RedactReplacement or Config.RedactReplacer says otherwise.
+const defaultRedactionReplacement = "[redacted]"
+
+var defaultRedactions = []*regexp.Regexp{
+	regexp.MustCompile(`cashuA[a-zA-Z0-9]+`),
+	regexp.MustCompile(`(?i)lnbc[a-z0-9]+`),
+	regexp.MustCompile(`(?i)npub1[a-z0-9]+`),
+	regexp.MustCompile(`(?i)nsec1[a-z0-9]+`),
+	regexp.MustCompile(`(?i)https?://[^\s"]*mint[^\s"]*`),
+}
+
+// PreviewRedaction returns the message and stack that capturing err here
+// would report, as captured and after redaction, so a consent screen can
+// show the user exactly what is removed before they approve sending. Each
+// is the message, then a blank line and the stack when stacks are
+// included. The configured redaction applies after Init; before it, the
+// default patterns do.
+func PreviewRedaction(err error) (original, redacted string) {
+	c := currentClient()
+	if c == nil {
+		c = &client{}
+	}
+	msg := eventMessage(CaptureEvent{Err: err})
+	stack, _ := c.eventStack(CaptureEvent{Err: err})
+
+	original = msg
+	redacted = truncateMessage(c.redact(msg), c.config.MaxMessageLength)
+	if stack != "" {
+		original += "\n\n" + stack
+		redacted += "\n\n" + c.redact(stack)
+	}
+	return original, redacted
+}
+
+// redactionPatterns returns the configured redaction patterns, or the
+// defaults if none are configured.
+func (c *client) redactionPatterns() []*regexp.Regexp {
+	if len(c.config.RedactPatterns) == 0 {
+		return defaultRedactions
+	}
+	return c.config.RedactPatterns
+}
+
+// redact replaces every match of the redaction patterns in input.
+// Matches of Config.NamedRedactPatterns become "[redacted:<name>]" and are
+// replaced first, so they keep their label even if an unnamed pattern would
+// also match. For the rest, Config.RedactReplacer, if set, computes each
+// replacement from the match; otherwise Config.RedactReplacement (default
+// "[redacted]") is used.
+func (c *client) redact(input string) string {
+	input = c.redactNamed(input)
+	replacer := c.config.RedactReplacer
+	if replacer == nil {
+		replacement := c.config.RedactReplacement
+		if replacement == "" {
+			replacement = defaultRedactionReplacement
+		}
+		replacer = func(string) string { return replacement }
+	}
+	for _, p := range c.redactionPatterns() {
+		input = p.ReplaceAllStringFunc(input, replacer)
+	}
+	return input
+}
+
+// redactNamed replaces matches of Config.NamedRedactPatterns, in name order
+// so the result doesn't depend on map iteration.
+func (c *client) redactNamed(input string) string {
+	named := c.config.NamedRedactPatterns
+	if len(named) == 0 {
+		return input
+	}
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if p := named[name]; p != nil {
+			input = p.ReplaceAllLiteralString(input, "[redacted:"+name+"]")
+		}
+	}
+	return input
+}
+
+// redactExtra normalizes extra data through JSON and redacts every string
+// in it, so secrets inside nested maps, slices, or structs are scrubbed too.
+// Values that can't be marshaled are replaced by their redacted %v form.
+func (c *client) redactExtra(extra map[string]interface{}) map[string]interface{} {
+	if len(extra) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(extra))
+	for k, v := range extra {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			out[k] = c.redact(fmt.Sprintf("%v", v))
+			continue
+		}
+		var normalized interface{}
+		if err := json.Unmarshal(raw, &normalized); err != nil {
+			out[k] = c.redact(string(raw))
+			continue
+		}
+		out[k] = c.redactValue(normalized)
+	}
+	return out
+}
+
+// redactValue redacts strings within a JSON-decoded value.
+func (c *client) redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return c.redact(val)
+	case []interface{}:
+		for i := range val {
+			val[i] = c.redactValue(val[i])
+		}
+		return val
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = c.redactValue(val[k])
+		}
+		return val
+	default:
+		return v
+	}
+}
@@ -0,0 +1,153 @@
+package bugstr
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Limits on walking a panic value for Config.ReflectPanicValue.
+const (
+	maxReflectDepth = 5
+	maxReflectItems = 50
+	maxReflectNodes = 1000
+)
+
+// reflectTruncated replaces whatever is left once maxReflectNodes values
+// have been walked.
+const reflectTruncated = "[truncated]"
+
+// panicValueFields returns the exported fields of a struct panic value as
+// JSON-friendly maps, or nil if the value is not a struct or pointer to
+// one. Nesting beyond maxReflectDepth and pointer cycles are replaced by
+// placeholder strings, slices and maps keep their first maxReflectItems
+// entries, and the walk stops after maxReflectNodes values in total.
+func panicValueFields(value interface{}) map[string]interface{} {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	w := &reflectWalk{seen: map[uintptr]bool{}, remaining: maxReflectNodes}
+	fields, _ := w.value(reflect.ValueOf(value), 0).(map[string]interface{})
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// reflectWalk is the state shared across one panicValueFields walk: the
+// pointers on the current path, for cycle detection, and how many more
+// values may be walked.
+type reflectWalk struct {
+	seen      map[uintptr]bool
+	remaining int
+}
+
+func (w *reflectWalk) value(v reflect.Value, depth int) interface{} {
+	if depth > maxReflectDepth {
+		return "[max depth]"
+	}
+	if w.remaining <= 0 {
+		return reflectTruncated
+	}
+	w.remaining--
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		if w.seen[v.Pointer()] {
+			return "[cycle]"
+		}
+		w.seen[v.Pointer()] = true
+		defer delete(w.seen, v.Pointer())
+		return w.value(v.Elem(), depth)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return w.value(v.Elem(), depth)
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			fields[t.Field(i).Name] = w.value(v.Field(i), depth+1)
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		n := min(v.Len(), maxReflectItems)
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			if w.remaining <= 0 {
+				return append(items, reflectTruncated)
+			}
+			items = append(items, w.value(v.Index(i), depth+1))
+		}
+		return items
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		entries := make(map[string]interface{})
+		iter := v.MapRange()
+		for iter.Next() && len(entries) < maxReflectItems {
+			if w.remaining <= 0 {
+				entries[reflectTruncated] = true
+				break
+			}
+			entries[fmt.Sprint(iter.Key().Interface())] = w.value(iter.Value(), depth+1)
+		}
+		return entries
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return fmt.Sprintf("[%s]", v.Kind())
+	default:
+		return fmt.Sprintf("%v", v.Interface())
+	}
+}
+
+// samePanicValue reports whether a and b are the same panic value. Values
+// that can't be compared with == match if they share a type and refer to
+// the same underlying data, e.g. the same slice or map.
+func samePanicValue(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		return !va.IsValid() && !vb.IsValid()
+	}
+	if va.Type() != vb.Type() {
+		return false
+	}
+	if va.Comparable() && vb.Comparable() {
+		return a == b
+	}
+	switch va.Kind() {
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	case reflect.Map, reflect.Func:
+		return va.Pointer() == vb.Pointer()
+	default:
+		return false
+	}
+}
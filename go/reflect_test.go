@@ -0,0 +1,114 @@
+package bugstr
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+type quotaError struct {
+	Account string
+	Limit   int
+	Tags    []string
+	Parent  *quotaError
+	secret  string
+}
+
+func (e *quotaError) Error() string { return "quota exceeded" }
+
+func TestReflectPanicValue(t *testing.T) {
+	value := &quotaError{Account: "npub1abc", Limit: 10, Tags: []string{"free"}, secret: "hidden"}
+	value.Parent = value
+
+	c := &client{config: Config{ReflectPanicValue: true}}
+	p := c.buildPayload(CaptureEvent{Err: &PanicError{Value: value}, Extra: map[string]interface{}{"request": "r1"}})
+	fields, ok := p.Extra["panic_value"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Extra = %v", p.Extra)
+	}
+	if fields["Account"] != "[redacted]" || fields["Limit"] != float64(10) || fields["Parent"] != "[cycle]" {
+		t.Fatalf("panic_value = %v", fields)
+	}
+	if _, ok := fields["secret"]; ok {
+		t.Fatal("unexported fields must not be reported")
+	}
+	if p.Extra["request"] != "r1" {
+		t.Fatal("capture Extra should be kept")
+	}
+
+	if p := (&client{}).buildPayload(CaptureEvent{Err: &PanicError{Value: value}}); p.Extra != nil {
+		t.Fatalf("without ReflectPanicValue Extra = %v", p.Extra)
+	}
+	if panicValueFields("just a string") != nil {
+		t.Fatal("non-struct values have no fields")
+	}
+}
+
+func TestReflectValueDepthCap(t *testing.T) {
+	type node struct{ Next interface{} }
+	var deep interface{} = "leaf"
+	for i := 0; i < maxReflectDepth+3; i++ {
+		deep = node{Next: deep}
+	}
+	v := panicValueFields(deep)
+	for i := 0; i < maxReflectDepth; i++ {
+		v = v["Next"].(map[string]interface{})
+	}
+	if v["Next"] != "[max depth]" {
+		t.Fatalf("expected depth cap, got %v", v["Next"])
+	}
+}
+
+func TestReflectValueNodeCap(t *testing.T) {
+	// Sharing one map per level keeps the input small while its expansion
+	// would be maxReflectItems^4 values.
+	var wide interface{} = "leaf"
+	for level := 0; level < 4; level++ {
+		m := make(map[string]interface{}, maxReflectItems)
+		for i := 0; i < maxReflectItems; i++ {
+			m[strconv.Itoa(i)] = wide
+		}
+		wide = m
+	}
+	fields := panicValueFields(struct{ Wide interface{} }{wide})
+
+	var nodes int
+	var truncated bool
+	var count func(v interface{})
+	count = func(v interface{}) {
+		nodes++
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if _, ok := v[reflectTruncated]; ok {
+				truncated = true
+			}
+			for _, child := range v {
+				count(child)
+			}
+		case string:
+			truncated = truncated || v == reflectTruncated
+		}
+	}
+	count(fields["Wide"])
+	if !truncated || nodes > 2*maxReflectNodes {
+		t.Fatalf("walked %d values, truncated=%v; want at most ~%d and a truncation marker", nodes, truncated, maxReflectNodes)
+	}
+}
+
+func TestSamePanicValue(t *testing.T) {
+	s := []int{1, 2}
+	m := map[string]int{}
+	err := errors.New("boom")
+	if !samePanicValue(err, err) || samePanicValue(err, errors.New("boom")) {
+		t.Fatal("comparable values should match by ==")
+	}
+	if !samePanicValue(s, s) || samePanicValue(s, []int{1, 2}) || samePanicValue(s, s[:1]) {
+		t.Fatal("slices should match only the same data")
+	}
+	if !samePanicValue(m, m) || samePanicValue(m, map[string]int{}) {
+		t.Fatal("maps should match only the same map")
+	}
+	if samePanicValue("1", 1) {
+		t.Fatal("values of different types should not match")
+	}
+}
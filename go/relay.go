@@ -0,0 +1,192 @@
+package bugstr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// publishToRelays publishes event to each relay in turn and returns nil
+// once Config.MinRelaySuccesses relays (default 1) have accepted it. If
+// every relay fails, the error wraps ErrAllRelaysFailed and each relay's
+// classified error; if some but too few accept, it wraps ErrTooFewRelays.
+func (c *client) publishToRelays(ctx context.Context, relays []string, event nostr.Event) error {
+	need := max(c.config.MinRelaySuccesses, 1)
+	accepted := 0
+	var errs []error
+	for _, relayURL := range relays {
+		err := c.publishToRelay(ctx, relayURL, event)
+		c.debugPublish(relayURL, event, err)
+		if err == nil {
+			if accepted++; accepted >= need {
+				return nil
+			}
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", relayURL, err))
+	}
+	if accepted > 0 {
+		err := fmt.Errorf("%w: %d of %d accepted", ErrTooFewRelays, accepted, need)
+		if len(errs) > 0 {
+			err = fmt.Errorf("%w: %w", err, errors.Join(errs...))
+		}
+		return err
+	}
+	if len(errs) == 0 {
+		return ErrAllRelaysFailed
+	}
+	return fmt.Errorf("%w: %w", ErrAllRelaysFailed, errors.Join(errs...))
+}
+
+// publishToAllRelays publishes event to every relay concurrently and returns
+// each relay's classified result, keyed by URL. A nil entry means the relay
+// accepted the event.
+func (c *client) publishToAllRelays(ctx context.Context, relays []string, event nostr.Event) map[string]error {
+	results := make(map[string]error, len(relays))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, relayURL := range relays {
+		wg.Add(1)
+		go func(relayURL string) {
+			defer wg.Done()
+			err := c.publishToRelay(ctx, relayURL, event)
+			c.debugPublish(relayURL, event, err)
+			mu.Lock()
+			results[relayURL] = err
+			mu.Unlock()
+		}(relayURL)
+	}
+	wg.Wait()
+	return results
+}
+
+// debugPublish logs one relay's publish result.
+func (c *client) debugPublish(relayURL string, event nostr.Event, err error) {
+	if err != nil {
+		c.debug("relay publish failed", "relay", relayURL, "event_id", event.ID, "error", err)
+		return
+	}
+	c.debug("relay accepted event", "relay", relayURL, "event_id", event.ID)
+}
+
+// publishToRelay publishes event to a single relay, reusing the pooled
+// connection if there is one and waiting out Config.RelayPublishInterval.
+// It fails with ErrBudgetExceeded if the upload doesn't fit in
+// Config.UploadBudgetBytesPerHour, and only a successful publish is
+// charged. With Config.VerifyPersistence it then queries the event back
+// by ID and fails with ErrNotPersisted if the relay doesn't return it.
+func (c *client) publishToRelay(ctx context.Context, relayURL string, event nostr.Event) (err error) {
+	size := len(event.String())
+	if !c.budget.fits(ctx, size) {
+		return ErrBudgetExceeded
+	}
+	if err := c.pacer.wait(ctx, relayURL); err != nil {
+		return err
+	}
+	relay, release, err := c.relayPool.acquire(ctx, relayURL, c.relayConnectTimeout())
+	if err != nil {
+		return err
+	}
+	defer func() { release(err) }()
+	if err := c.publish(ctx, relay, event); err != nil {
+		return err
+	}
+	c.budget.charge(size)
+	if c.config.VerifyPersistence {
+		return verifyPersisted(ctx, relay, event.ID)
+	}
+	return nil
+}
+
+// publish publishes event on relay. If the relay asks for payment with an
+// invoice and Config.PayInvoice is set, the invoice is paid and the publish
+// retried once.
+func (c *client) publish(ctx context.Context, relay *nostr.Relay, event nostr.Event) error {
+	err := relay.Publish(ctx, event)
+	if err == nil {
+		return nil
+	}
+	err = classifyRelayError(err)
+	invoice := paymentInvoice(err)
+	if invoice == "" || c.config.PayInvoice == nil {
+		return err
+	}
+	if payErr := c.config.PayInvoice(invoice); payErr != nil {
+		return fmt.Errorf("%w: paying invoice: %w", err, payErr)
+	}
+	if err := relay.Publish(ctx, event); err != nil {
+		return classifyRelayError(err)
+	}
+	return nil
+}
+
+// defaultRelayConnectTimeout is the Config.RelayConnectTimeout default.
+const defaultRelayConnectTimeout = 10 * time.Second
+
+func (c *client) relayConnectTimeout() time.Duration {
+	if c.config.RelayConnectTimeout > 0 {
+		return c.config.RelayConnectTimeout
+	}
+	return defaultRelayConnectTimeout
+}
+
+// verifyPersisted queries relay for the event with id.
+func verifyPersisted(ctx context.Context, relay *nostr.Relay, id string) error {
+	events, err := relay.QuerySync(ctx, nostr.Filter{IDs: []string{id}})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotPersisted, err)
+	}
+	for _, event := range events {
+		if event.ID == id {
+			return nil
+		}
+	}
+	return ErrNotPersisted
+}
+
+// RelayStatus is the result of probing one relay with TestConnection.
+type RelayStatus struct {
+	URL       string
+	Connected bool
+	// LatencyMs is the time taken to establish the websocket connection.
+	LatencyMs int
+	Error     error
+}
+
+// TestConnection connects to each relay in parallel without publishing
+// anything and reports whether it is reachable and how long the connection
+// took. Useful for a settings or diagnostics screen. If relays is empty,
+// the relays configured for Config.Environment (or the defaults) are
+// probed. Results are in the same order as the relays probed.
+func TestConnection(ctx context.Context, relays []string) []RelayStatus {
+	if len(relays) == 0 {
+		relays = defaultRelays
+		if c := currentClient(); c != nil {
+			relays = c.relaysFor(c.config.Environment)
+		}
+	}
+
+	statuses := make([]RelayStatus, len(relays))
+	var wg sync.WaitGroup
+	for i, relayURL := range relays {
+		wg.Add(1)
+		go func(i int, relayURL string) {
+			defer wg.Done()
+			start := time.Now()
+			relay, err := nostr.RelayConnect(ctx, relayURL)
+			status := RelayStatus{URL: relayURL, Error: err}
+			if err == nil {
+				status.Connected = true
+				status.LatencyMs = int(time.Since(start).Milliseconds())
+				relay.Close()
+			}
+			statuses[i] = status
+		}(i, relayURL)
+	}
+	wg.Wait()
+	return statuses
+}
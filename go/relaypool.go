@@ -0,0 +1,106 @@
+package bugstr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayIdleTimeout is how long a pooled relay connection stays open
+// without use. Bursts of reports share a connection; a quiet process
+// doesn't hold websockets open indefinitely.
+const relayIdleTimeout = 2 * time.Minute
+
+// relayPool keeps relay connections open across reports, reconnecting
+// lazily when a connection has dropped.
+type relayPool struct {
+	mu     sync.Mutex
+	relays map[string]*pooledRelay
+}
+
+type pooledRelay struct {
+	relay *nostr.Relay
+	idle  *time.Timer
+}
+
+func newRelayPool() *relayPool {
+	return &relayPool{relays: make(map[string]*pooledRelay)}
+}
+
+// acquire returns a connected relay for url and a release function to call
+// with the outcome of using it. A failed use drops the connection so the
+// next report reconnects. A nil pool connects afresh and release closes it.
+// Connecting is bounded by connectTimeout as well as ctx.
+func (p *relayPool) acquire(ctx context.Context, url string, connectTimeout time.Duration) (*nostr.Relay, func(error), error) {
+	if p == nil {
+		relay, err := connectRelay(ctx, url, connectTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		return relay, func(error) { relay.Close() }, nil
+	}
+
+	p.mu.Lock()
+	if pooled, ok := p.relays[url]; ok && pooled.relay.IsConnected() {
+		pooled.idle.Reset(relayIdleTimeout)
+		p.mu.Unlock()
+		return pooled.relay, p.releaser(url, pooled.relay), nil
+	}
+	p.mu.Unlock()
+
+	relay, err := connectRelay(ctx, url, connectTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pooled, ok := p.relays[url]; ok {
+		if pooled.relay.IsConnected() {
+			// Another report connected concurrently; share its connection.
+			pooled.idle.Reset(relayIdleTimeout)
+			go relay.Close()
+			return pooled.relay, p.releaser(url, pooled.relay), nil
+		}
+		pooled.idle.Stop()
+		go pooled.relay.Close()
+	}
+	p.relays[url] = &pooledRelay{
+		relay: relay,
+		idle:  time.AfterFunc(relayIdleTimeout, func() { p.drop(url, relay) }),
+	}
+	return relay, p.releaser(url, relay), nil
+}
+
+func (p *relayPool) releaser(url string, relay *nostr.Relay) func(error) {
+	return func(err error) {
+		if err != nil {
+			p.drop(url, relay)
+		}
+	}
+}
+
+// drop closes relay and removes it from the pool if it is still the
+// pooled connection for url.
+func (p *relayPool) drop(url string, relay *nostr.Relay) {
+	p.mu.Lock()
+	pooled, ok := p.relays[url]
+	if !ok || pooled.relay != relay {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.relays, url)
+	p.mu.Unlock()
+	pooled.idle.Stop()
+	relay.Close()
+}
+
+// connectRelay connects to url, giving up after timeout so one hanging
+// relay can't use up the whole send timeout.
+func connectRelay(ctx context.Context, url string, timeout time.Duration) (*nostr.Relay, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return nostr.RelayConnect(ctx, url)
+}
@@ -0,0 +1,1004 @@
+package bugstr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alltheseas/bugstr/go/bugstrtest"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// resetForTest clears package state so a test can call Init again.
+func resetForTest(t *testing.T) {
+	t.Helper()
+	initMu.Lock()
+	defer initMu.Unlock()
+	active.Store(nil)
+}
+
+// skipRelayUnderRace skips tests that talk to a relay when the race
+// detector is on. go-nostr (as of v0.52) races between Relay.Close and the
+// goroutine Connect starts to clear Relay.Connection, so these tests fail
+// under -race however bugstr behaves. Tests of bugstr's own concurrency,
+// such as TestConcurrentInitAndCapture, use a Transport and still run.
+func skipRelayUnderRace(t *testing.T) {
+	t.Helper()
+	if raceEnabled {
+		t.Skip("go-nostr relay connections race under -race")
+	}
+}
+
+func TestRoundTripThroughEmbeddedRelay(t *testing.T) {
+	skipRelayUnderRace(t)
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	developerKey := nostr.GeneratePrivateKey()
+	developerPubkey, _ := nostr.GetPublicKey(developerKey)
+
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Relays:          []string{relay.URL},
+		Environment:     "test",
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	CaptureMessage("round trip nsec1qqqqqqqqqqqqqqqq")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	giftWrap, err := relay.WaitForEvent(ctx, nostr.Filter{Kinds: []int{1059}, Tags: nostr.TagMap{"p": {developerPubkey}}})
+	if err != nil {
+		t.Fatalf("no gift wrap published: %v", err)
+	}
+
+	rumor, err := bugstrtest.OpenGiftWrap(giftWrap, developerKey)
+	if err != nil {
+		t.Fatalf("OpenGiftWrap: %v", err)
+	}
+	if rumor.Kind != 14 {
+		t.Fatalf("expected kind 14 rumor, got %d", rumor.Kind)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal([]byte(decodeContent(t, rumor.Content)), &payload); err != nil {
+		t.Fatalf("rumor content is not a payload: %v", err)
+	}
+	if payload.Message != "round trip [redacted]" {
+		t.Fatalf("unexpected message %q", payload.Message)
+	}
+	if payload.Environment != "test" {
+		t.Fatalf("unexpected environment %q", payload.Environment)
+	}
+}
+
+// decodeContent reverses maybeCompress.
+func decodeContent(t *testing.T, content string) string {
+	t.Helper()
+	plaintext, err := DecompressEnvelope(content)
+	if err != nil {
+		t.Fatalf("DecompressEnvelope: %v", err)
+	}
+	return plaintext
+}
+
+func TestRecoverReportsOriginalCrashSite(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var reported *Payload
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			reported = p
+			return nil
+		}),
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	sentinel := errors.New("sentinel")
+	var repanicked interface{}
+	func() {
+		defer func() { repanicked = recover() }()
+		defer Recover()
+		panicSite(sentinel)
+	}()
+
+	if repanicked != sentinel {
+		t.Fatalf("re-panic value = %v, want original %v", repanicked, sentinel)
+	}
+	if reported == nil {
+		t.Fatal("Recover returned before the report was delivered")
+	}
+	if reported.Level != LevelFatal {
+		t.Fatalf("Level = %q", reported.Level)
+	}
+	if !strings.Contains(reported.Stack, "panicSite") {
+		t.Fatalf("report stack missing crash site:\n%s", reported.Stack)
+	}
+}
+
+func panicSite(v interface{}) {
+	panic(v)
+}
+
+// TestConcurrentInitAndCapture is meaningful under -race: captures racing
+// Init must see either no client or a complete one.
+func TestConcurrentInitAndCapture(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	var sent sync.WaitGroup
+	cfg := Config{
+		DeveloperPubkey: developerPubkey,
+		Environment:     "race",
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			defer sent.Done()
+			if p.Environment != "race" {
+				t.Errorf("capture saw incomplete config: %+v", p)
+			}
+			return nil
+		}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			Init(cfg)
+		}()
+		go func() {
+			defer wg.Done()
+			if currentClient() != nil {
+				sent.Add(1)
+				CaptureMessage("concurrent")
+			}
+		}()
+	}
+	wg.Wait()
+	sent.Wait()
+}
+
+func TestArchiveRelaysReceiveEveryReport(t *testing.T) {
+	skipRelayUnderRace(t)
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	primary := bugstrtest.NewRelay()
+	defer primary.Close()
+	archive := bugstrtest.NewRelay()
+	defer archive.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Relays:          []string{primary.URL},
+		ArchiveRelays:   []string{archive.URL},
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := CaptureExceptionSync(ctx, errors.New("archived")); err != nil {
+		t.Fatalf("CaptureExceptionSync: %v", err)
+	}
+
+	if len(primary.Events()) != 1 || len(archive.Events()) != 1 {
+		t.Fatalf("primary got %d events, archive got %d; want 1 each", len(primary.Events()), len(archive.Events()))
+	}
+	if primary.Events()[0].ID != archive.Events()[0].ID {
+		t.Fatal("archive should receive the same gift wrap as the primary relay")
+	}
+}
+
+func TestTestConnection(t *testing.T) {
+	skipRelayUnderRace(t)
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	statuses := TestConnection(ctx, []string{relay.URL, "ws://127.0.0.1:1"})
+	if len(statuses) != 2 {
+		t.Fatalf("got %d statuses, want 2", len(statuses))
+	}
+	if !statuses[0].Connected || statuses[0].Error != nil {
+		t.Fatalf("embedded relay should connect: %+v", statuses[0])
+	}
+	if statuses[1].Connected || statuses[1].Error == nil {
+		t.Fatalf("closed port should fail: %+v", statuses[1])
+	}
+	if len(relay.Events()) != 0 {
+		t.Fatal("TestConnection must not publish")
+	}
+}
+
+func TestSendTestReport(t *testing.T) {
+	skipRelayUnderRace(t)
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Relays:          []string{relay.URL},
+		MinLevel:        LevelFatal,
+		DeviceInfoFunc:  func() map[string]string { return map[string]string{"model": "phone"} },
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	SetContext("user", map[string]interface{}{"plan": "pro"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	report, err := SendTestReport(ctx)
+	if err != nil {
+		t.Fatalf("SendTestReport: %v", err)
+	}
+	if report.Payload.Message != testReportMessage {
+		t.Fatalf("unexpected message %q", report.Payload.Message)
+	}
+	if p := report.Payload; p.Stack != "" || p.Logs != nil || p.Device != nil || p.Contexts != nil {
+		t.Fatalf("test report should carry no user data: %+v", p)
+	}
+	events := relay.Events()
+	if len(events) != 1 || len(report.EventIDs) != 1 || report.EventIDs[0] != events[0].ID {
+		t.Fatalf("event IDs %v don't match published events", report.EventIDs)
+	}
+}
+
+func TestVerifyPersistenceSkipsDroppingRelay(t *testing.T) {
+	skipRelayUnderRace(t)
+	dropping := bugstrtest.NewRelay()
+	defer dropping.Close()
+	dropping.DiscardEvents(true)
+	storing := bugstrtest.NewRelay()
+	defer storing.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	c := &client{
+		config: Config{
+			Relays:            []string{dropping.URL, storing.URL},
+			VerifyPersistence: true,
+		},
+		senderPrivkey:      nostr.GeneratePrivateKey(),
+		developerPubkeyHex: developerPubkey,
+	}
+	c.senderPubkeyHex, _ = nostr.GetPublicKey(c.senderPrivkey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.sendToNostr(ctx, &Payload{Message: "verify me"}); err != nil {
+		t.Fatalf("sendToNostr: %v", err)
+	}
+	if len(storing.Events()) != 1 {
+		t.Fatal("report should fall through to the relay that persists it")
+	}
+
+	c.config.Relays = []string{dropping.URL}
+	if err := c.sendToNostr(ctx, &Payload{Message: "verify me"}); !errors.Is(err, ErrNotPersisted) {
+		t.Fatalf("got %v, want ErrNotPersisted", err)
+	}
+}
+
+func TestCaptureExceptionAsyncReportsResult(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	if err := <-CaptureExceptionAsync(errors.New("early")); !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("before Init: got %v, want ErrNotInitialized", err)
+	}
+
+	sendErr := errors.New("relay down")
+	var onError error
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			return sendErr
+		}),
+		OnError: func(err error) { onError = err },
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := <-CaptureExceptionAsync(errors.New("boom")); err != sendErr {
+		t.Fatalf("got %v, want %v", err, sendErr)
+	}
+	if onError != nil {
+		t.Fatalf("OnError should not be called, got %v", onError)
+	}
+}
+
+func TestRelayPoolReusesConnection(t *testing.T) {
+	skipRelayUnderRace(t)
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	c := &client{
+		config:             Config{Relays: []string{relay.URL}},
+		senderPrivkey:      nostr.GeneratePrivateKey(),
+		developerPubkeyHex: developerPubkey,
+		relayPool:          newRelayPool(),
+	}
+	c.senderPubkeyHex, _ = nostr.GetPublicKey(c.senderPrivkey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.sendToNostr(ctx, &Payload{Message: "first"}); err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	first := c.relayPool.relays[relay.URL].relay
+	if err := c.sendToNostr(ctx, &Payload{Message: "second"}); err != nil {
+		t.Fatalf("second send: %v", err)
+	}
+	if c.relayPool.relays[relay.URL].relay != first {
+		t.Fatal("second report should reuse the pooled connection")
+	}
+	if len(relay.Events()) != 2 {
+		t.Fatalf("got %d events, want 2", len(relay.Events()))
+	}
+
+	first.Close()
+	if err := c.sendToNostr(ctx, &Payload{Message: "third"}); err != nil {
+		t.Fatalf("send after disconnect: %v", err)
+	}
+	if c.relayPool.relays[relay.URL].relay == first {
+		t.Fatal("dropped connection should be replaced")
+	}
+}
+
+func TestRelayConnectTimeoutSkipsHangingRelay(t *testing.T) {
+	skipRelayUnderRace(t)
+	// Accepts TCP connections but never completes the websocket handshake.
+	hanging, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hanging.Close()
+	go func() {
+		for {
+			conn, err := hanging.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	c := &client{
+		config: Config{
+			Relays:              []string{"ws://" + hanging.Addr().String(), relay.URL},
+			RelayConnectTimeout: 200 * time.Millisecond,
+		},
+		senderPrivkey:      nostr.GeneratePrivateKey(),
+		developerPubkeyHex: developerPubkey,
+	}
+	c.senderPubkeyHex, _ = nostr.GetPublicKey(c.senderPrivkey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.sendToNostr(ctx, &Payload{Message: "hang"}); err != nil {
+		t.Fatalf("sendToNostr: %v", err)
+	}
+	if len(relay.Events()) != 1 {
+		t.Fatal("report should reach the relay after the hanging one")
+	}
+}
+
+func TestSignerHoldsSenderIdentity(t *testing.T) {
+	skipRelayUnderRace(t)
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	signer, err := keyer.NewPlainKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerPubkey, _ := signer.GetPublicKey(context.Background())
+	developerKey := nostr.GeneratePrivateKey()
+	developerPubkey, _ := nostr.GetPublicKey(developerKey)
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Relays:          []string{relay.URL},
+		Signer:          signer,
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := CaptureExceptionSync(ctx, errors.New("signed")); err != nil {
+		t.Fatalf("CaptureExceptionSync: %v", err)
+	}
+	rumor, err := bugstrtest.OpenGiftWrap(relay.Events()[0], developerKey)
+	if err != nil {
+		t.Fatalf("OpenGiftWrap: %v", err)
+	}
+	if rumor.PubKey != signerPubkey {
+		t.Fatalf("rumor pubkey %s, want signer %s", rumor.PubKey, signerPubkey)
+	}
+}
+
+func TestInitRejectsSignerWithDeveloperKey(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	developerKey := nostr.GeneratePrivateKey()
+	developerPubkey, _ := nostr.GetPublicKey(developerKey)
+	signer, err := keyer.NewPlainKeySigner(developerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Init(Config{DeveloperPubkey: developerPubkey, Signer: signer})
+	if err == nil || !strings.Contains(err.Error(), "must differ") {
+		t.Fatalf("Init err = %v, want DeveloperPubkey must differ from the sender", err)
+	}
+}
+
+func TestGiftWrapTimestampOrdering(t *testing.T) {
+	skipRelayUnderRace(t)
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	developerKey := nostr.GeneratePrivateKey()
+	developerPubkey, _ := nostr.GetPublicKey(developerKey)
+	senderKey := nostr.GeneratePrivateKey()
+	senderPubkey, _ := nostr.GetPublicKey(senderKey)
+	c := &client{
+		config:             Config{Relays: []string{relay.URL}},
+		senderPrivkey:      senderKey,
+		senderPubkeyHex:    senderPubkey,
+		developerPubkeyHex: developerPubkey,
+	}
+
+	captured := time.Now().Add(-time.Minute)
+	for i := 0; i < 5; i++ {
+		if err := c.sendToNostr(context.Background(), &Payload{Message: "ordered", Timestamp: captured.UnixMilli()}); err != nil {
+			t.Fatalf("sendToNostr: %v", err)
+		}
+	}
+	now := nostr.Now()
+	for _, wrap := range relay.Events() {
+		sealKey, _ := nip44.GenerateConversationKey(wrap.PubKey, developerKey)
+		sealJSON, err := nip44.Decrypt(wrap.Content, sealKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var seal nostr.Event
+		if err := json.Unmarshal([]byte(sealJSON), &seal); err != nil {
+			t.Fatal(err)
+		}
+		rumor, err := bugstrtest.OpenGiftWrap(wrap, developerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rumor.CreatedAt != nostr.Timestamp(captured.Unix()) {
+			t.Errorf("rumor created_at %d, want capture time %d", rumor.CreatedAt, captured.Unix())
+		}
+		if seal.CreatedAt < now-nostr.Timestamp(maxTimestampJitter) || seal.CreatedAt > now {
+			t.Errorf("seal created_at %d outside the jitter window", seal.CreatedAt)
+		}
+		if wrap.CreatedAt < seal.CreatedAt || wrap.CreatedAt > now {
+			t.Errorf("wrap created_at %d not in [seal %d, now %d]", wrap.CreatedAt, seal.CreatedAt, now)
+		}
+	}
+}
+
+func TestOnEventSeesPublishedGiftWrap(t *testing.T) {
+	skipRelayUnderRace(t)
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	senderKey := nostr.GeneratePrivateKey()
+	senderPubkey, _ := nostr.GetPublicKey(senderKey)
+	var kinds []int
+	var ids []string
+	c := &client{
+		config: Config{Relays: []string{relay.URL}, OnEvent: func(kind int, event *nostr.Event) {
+			kinds = append(kinds, kind)
+			ids = append(ids, event.ID)
+			event.Content = "tampered"
+		}},
+		senderPrivkey:      senderKey,
+		senderPubkeyHex:    senderPubkey,
+		developerPubkeyHex: developerPubkey,
+	}
+	if err := c.sendToNostr(context.Background(), &Payload{Message: "audited"}); err != nil {
+		t.Fatalf("sendToNostr: %v", err)
+	}
+	published := relay.Events()
+	if len(kinds) != 1 || kinds[0] != 1059 || ids[0] != published[0].ID {
+		t.Fatalf("OnEvent saw kinds %v ids %v, published %s", kinds, ids, published[0].ID)
+	}
+	if published[0].Content == "tampered" {
+		t.Fatal("OnEvent must not be able to modify the published event")
+	}
+}
+
+func TestThreadReports(t *testing.T) {
+	skipRelayUnderRace(t)
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	developerKey := nostr.GeneratePrivateKey()
+	developerPubkey, _ := nostr.GetPublicKey(developerKey)
+	senderKey := nostr.GeneratePrivateKey()
+	senderPubkey, _ := nostr.GetPublicKey(senderKey)
+	c := &client{
+		config:             Config{Relays: []string{relay.URL}, ThreadReports: true},
+		senderPrivkey:      senderKey,
+		senderPubkeyHex:    senderPubkey,
+		developerPubkeyHex: developerPubkey,
+	}
+
+	for _, session := range []string{"s1", "s1", "s1", "s2"} {
+		if err := c.sendToNostr(context.Background(), &Payload{Message: "cascade", SessionID: session}); err != nil {
+			t.Fatalf("sendToNostr: %v", err)
+		}
+	}
+	var rumors []*nostr.Event
+	for _, wrap := range relay.Events() {
+		rumor, err := bugstrtest.OpenGiftWrap(wrap, developerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rumors = append(rumors, rumor)
+	}
+
+	if root := rumors[0].Tags.GetFirst([]string{"e"}); root != nil {
+		t.Fatalf("first report should start the thread, got %v", root)
+	}
+	for _, rumor := range rumors[1:3] {
+		tag := rumor.Tags.GetFirst([]string{"e"})
+		if tag == nil || (*tag)[1] != rumors[0].ID || (*tag)[3] != "root" {
+			t.Fatalf("e tag = %v, want root %s", tag, rumors[0].ID)
+		}
+	}
+	if root := rumors[3].Tags.GetFirst([]string{"e"}); root != nil {
+		t.Fatalf("a new session should start a new thread, got %v", root)
+	}
+}
+
+// refusingSigner is a Signer whose encryption always fails.
+type refusingSigner struct{ nostr.Keyer }
+
+func (refusingSigner) Encrypt(context.Context, string, string) (string, error) {
+	return "", errors.New("user rejected")
+}
+
+func TestEncryptionFailureWritesFallback(t *testing.T) {
+	relay := bugstrtest.NewRelay()
+	defer relay.Close()
+
+	signer, err := keyer.NewPlainKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	var fallback bytes.Buffer
+	c := &client{
+		config: Config{
+			Relays:         []string{relay.URL},
+			Signer:         refusingSigner{signer},
+			FallbackWriter: &fallback,
+		},
+		developerPubkeyHex: developerPubkey,
+	}
+
+	// Large enough to be compressed on the way to relays; the fallback
+	// still gets the plain redacted JSON.
+	payload := c.buildPayload(CaptureEvent{Message: "unsealable nsec1secretkey " + strings.Repeat("x", 2048)})
+	err = c.sendToNostr(context.Background(), payload)
+	if !errors.Is(err, ErrEncryptionFailed) {
+		t.Fatalf("err = %v, want ErrEncryptionFailed", err)
+	}
+	var got Payload
+	if err := json.Unmarshal(fallback.Bytes(), &got); err != nil || !strings.HasPrefix(got.Message, "unsealable [redacted] ") {
+		t.Fatalf("fallback = %.100q (%v)", fallback.String(), err)
+	}
+	if strings.Contains(fallback.String(), "nsec1") {
+		t.Fatal("fallback must not contain the unredacted payload")
+	}
+	if len(relay.Events()) != 0 {
+		t.Fatal("nothing should be published when encryption fails")
+	}
+}
+
+func TestCaptureFromCallbackIsSuppressed(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var mu sync.Mutex
+	var sent []string
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			mu.Lock()
+			sent = append(sent, p.Message)
+			mu.Unlock()
+			return errors.New("relay down")
+		}),
+		OnError: func(err error) { CaptureException(err) },
+		BeforeSend: func(p *Payload) *Payload {
+			CaptureMessage("from BeforeSend")
+			return p
+		},
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	CaptureMessage("original")
+	if err := Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	CaptureExceptionSync(context.Background(), errors.New("sync original"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(sent, ",") != "original,sync original" {
+		t.Fatalf("sent %v, want only the original reports", sent)
+	}
+}
+
+// panickingSigner is a Signer whose encryption panics.
+type panickingSigner struct{ nostr.Keyer }
+
+func (panickingSigner) Encrypt(context.Context, string, string) (string, error) {
+	panic("bad key length")
+}
+
+func TestEncryptionPanicReachesOnError(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	signer, err := keyer.NewPlainKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	errs := make(chan error, 1)
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Relays:          []string{"ws://127.0.0.1:1"},
+		Signer:          panickingSigner{signer},
+		OnError:         func(err error) { errs <- err },
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	CaptureMessage("boom")
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrEncryptionFailed) || !strings.Contains(err.Error(), "bad key length") {
+			t.Fatalf("OnError got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError not called")
+	}
+}
+
+func TestCaptureFromChannel(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var mu sync.Mutex
+	var sent []string
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			mu.Lock()
+			sent = append(sent, p.Message)
+			mu.Unlock()
+			return nil
+		}),
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	errs := make(chan error, 3)
+	errs <- errors.New("worker 1 failed")
+	errs <- nil
+	close(errs)
+	CaptureFromChannel(context.Background(), errs)
+
+	// After cancellation, buffered errors are still drained.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs = make(chan error, 2)
+	errs <- errors.New("worker 2 failed")
+	errs <- errors.New("worker 3 failed")
+	done := make(chan struct{})
+	go func() {
+		CaptureFromChannel(ctx, errs)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CaptureFromChannel did not return after cancellation")
+	}
+	if err := Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(sent)
+	if strings.Join(sent, ",") != "worker 1 failed,worker 2 failed,worker 3 failed" {
+		t.Fatalf("sent %v", sent)
+	}
+}
+
+func TestNestedRecoverReportsOnce(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var reports int
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			reports++
+			return nil
+		}),
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	sentinel := errors.New("sentinel")
+	var repanicked interface{}
+	func() {
+		defer func() { repanicked = recover() }()
+		defer Recover()
+		func() {
+			defer Recover()
+			func() {
+				defer Recover()
+				panicSite(sentinel)
+			}()
+		}()
+	}()
+	if repanicked != sentinel {
+		t.Fatalf("re-panic value = %v, want %v", repanicked, sentinel)
+	}
+	if reports != 1 {
+		t.Fatalf("got %d reports for one panic, want 1", reports)
+	}
+
+	// A later, separate panic in the same goroutine is reported again.
+	func() {
+		defer func() { recover() }()
+		defer Recover()
+		panicSite(sentinel)
+	}()
+	if reports != 2 {
+		t.Fatalf("second panic: got %d reports, want 2", reports)
+	}
+}
+
+func TestPanicInDeferDuringRecoverIsReported(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var messages []string
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			messages = append(messages, p.Message)
+			return nil
+		}),
+	}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	// The inner Recover reports and re-panics "first"; while that unwinds,
+	// a deferred cleanup panics with a new value the outer Recover must
+	// report rather than take for the re-panic. Running it twice in one
+	// goroutine checks that the first unwinding leaves nothing behind.
+	for run := 0; run < 2; run++ {
+		messages = nil
+		var repanicked interface{}
+		func() {
+			defer func() { repanicked = recover() }()
+			defer Recover()
+			defer func() { panic("second") }()
+			defer Recover()
+			panicSite("first")
+		}()
+		if repanicked != "second" {
+			t.Fatalf("run %d: re-panic value = %v, want second", run, repanicked)
+		}
+		if len(messages) != 2 || !strings.Contains(messages[0], "first") || !strings.Contains(messages[1], "second") {
+			t.Fatalf("run %d: reported %q, want first then second", run, messages)
+		}
+	}
+}
+
+func TestSessionID(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	var reported []*Payload
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	cfg := Config{
+		DeveloperPubkey: developerPubkey,
+		Transport: TransportFunc(func(ctx context.Context, p *Payload) error {
+			reported = append(reported, p)
+			return nil
+		}),
+	}
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	ctx := context.Background()
+	CaptureExceptionSync(ctx, errors.New("one"))
+	CaptureExceptionSync(ctx, errors.New("two"))
+	SetSessionID("custom")
+	CaptureExceptionSync(ctx, errors.New("three"))
+
+	resetForTest(t)
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	CaptureExceptionSync(ctx, errors.New("four"))
+
+	if reported[0].SessionID == "" || reported[0].SessionID != reported[1].SessionID {
+		t.Fatalf("reports in one session should share an ID: %q, %q", reported[0].SessionID, reported[1].SessionID)
+	}
+	if reported[2].SessionID != "custom" {
+		t.Fatalf("SetSessionID: got %q", reported[2].SessionID)
+	}
+	if reported[3].SessionID == reported[0].SessionID || reported[3].SessionID == "custom" {
+		t.Fatalf("a new Init should start a new session, got %q", reported[3].SessionID)
+	}
+}
+
+func TestInitRejectsUnknownCompression(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err := Init(Config{DeveloperPubkey: developerPubkey, Compression: "zstd"}); err == nil {
+		t.Fatal("Init should reject an unknown compression mode")
+	}
+}
+
+func TestMinRelaySuccesses(t *testing.T) {
+	skipRelayUnderRace(t)
+	first, second := bugstrtest.NewRelay(), bugstrtest.NewRelay()
+	defer first.Close()
+	defer second.Close()
+	down := bugstrtest.NewRelay()
+	down.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	senderKey := nostr.GeneratePrivateKey()
+	senderPubkey, _ := nostr.GetPublicKey(senderKey)
+	c := &client{
+		config:             Config{Relays: []string{first.URL, down.URL, second.URL}, MinRelaySuccesses: 2},
+		senderPrivkey:      senderKey,
+		senderPubkeyHex:    senderPubkey,
+		developerPubkeyHex: developerPubkey,
+	}
+	if err := c.sendToNostr(context.Background(), &Payload{Message: "durable"}); err != nil {
+		t.Fatalf("sendToNostr: %v", err)
+	}
+	if len(first.Events()) != 1 || len(second.Events()) != 1 {
+		t.Fatal("report should reach two relays, skipping the one that is down")
+	}
+
+	c.config.Relays = []string{first.URL, down.URL}
+	err := c.sendToNostr(context.Background(), &Payload{Message: "durable"})
+	if !errors.Is(err, ErrTooFewRelays) || errors.Is(err, ErrAllRelaysFailed) {
+		t.Fatalf("err = %v, want ErrTooFewRelays", err)
+	}
+
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+	if err := Init(Config{DeveloperPubkey: developerPubkey, Relays: []string{first.URL}, MinRelaySuccesses: 2}); err == nil {
+		t.Fatal("Init should reject MinRelaySuccesses above the number of Relays")
+	}
+}
+
+func TestUploadBudgetChargesEveryRelay(t *testing.T) {
+	skipRelayUnderRace(t)
+	first, second := bugstrtest.NewRelay(), bugstrtest.NewRelay()
+	defer first.Close()
+	defer second.Close()
+	down := bugstrtest.NewRelay()
+	down.Close()
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	senderKey := nostr.GeneratePrivateKey()
+	senderPubkey, _ := nostr.GetPublicKey(senderKey)
+	// A frozen clock keeps the bucket from draining while the test runs.
+	now := time.Now()
+	budget := newUploadBudget(1 << 20)
+	budget.now = func() time.Time { return now }
+	c := &client{
+		config:             Config{Relays: []string{first.URL, down.URL, second.URL}, MinRelaySuccesses: 2},
+		senderPrivkey:      senderKey,
+		senderPubkeyHex:    senderPubkey,
+		developerPubkeyHex: developerPubkey,
+		budget:             budget,
+	}
+	if err := c.sendToNostr(context.Background(), &Payload{Message: "charged"}); err != nil {
+		t.Fatalf("sendToNostr: %v", err)
+	}
+	events := first.Events()
+	if len(events) != 1 || len(second.Events()) != 1 {
+		t.Fatal("report should reach both relays")
+	}
+	size := float64(len(events[0].String()))
+	if level := c.budget.level; level != 2*size {
+		t.Fatalf("budget charged %.0f bytes, want 2 x %.0f; the relay that is down must cost nothing", level, size)
+	}
+
+	// A budget with room for one upload skips the second relay.
+	c.budget = newUploadBudget(int(size * 1.5))
+	c.config.Relays = []string{first.URL, second.URL}
+	err := c.sendToNostr(context.Background(), &Payload{Message: "charged"})
+	if !errors.Is(err, ErrTooFewRelays) || !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("err = %v, want ErrTooFewRelays wrapping ErrBudgetExceeded", err)
+	}
+}
+
+func TestMaxRelays(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	developerPubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	many := make([]string, 9)
+	for i := range many {
+		many[i] = fmt.Sprintf("wss://relay%d.example", i)
+	}
+	if err := Init(Config{DeveloperPubkey: developerPubkey, Relays: many}); err == nil {
+		t.Fatal("Init should reject more relays than the default MaxRelays")
+	}
+	if err := Init(Config{DeveloperPubkey: developerPubkey, RelaysByEnvironment: map[string][]string{"prod": many}}); err == nil {
+		t.Fatal("Init should reject an oversized RelaysByEnvironment entry")
+	}
+	if err := Init(Config{DeveloperPubkey: developerPubkey, Relays: many, MaxRelays: 10}); err != nil {
+		t.Fatalf("raised MaxRelays: %v", err)
+	}
+
+	c := &client{config: Config{MaxRelays: 2, RelayProvider: func() []string { return many }}}
+	if got := c.relaysFor(""); len(got) != 2 {
+		t.Fatalf("provider relays should be capped, got %d", len(got))
+	}
+}
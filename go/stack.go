@@ -0,0 +1,279 @@
+package bugstr
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mainPackagePath returns the import path of the main package, used to
+// name files in package main. Falls back to "main" when build info is
+// missing.
+var mainPackagePath = sync.OnceValue(func() string {
+	info, _ := debug.ReadBuildInfo()
+	return mainPackagePathFrom(info)
+})
+
+// mainPackagePathFrom returns the main package path recorded in info, as
+// printed by -trimpath: e.g. example.com/mp/cmd/app rather than the module
+// path example.com/mp.
+func mainPackagePathFrom(info *debug.BuildInfo) string {
+	switch {
+	case info == nil:
+		return "main"
+	case info.Path != "":
+		return info.Path
+	case info.Main.Path != "":
+		return info.Main.Path
+	default:
+		return "main"
+	}
+}
+
+// trimStackPaths rewrites the absolute source paths in a Go stack trace to
+// their package import path, the same form produced by `go build -trimpath`:
+//
+//	/home/bob/go/pkg/mod/github.com/x/y@v1.2.3/pkg/t.go:34 +0x55
+//
+// becomes
+//
+//	github.com/x/y/pkg/t.go:34 +0x55
+//
+// This drops usernames and machine layout while keeping file:line intact.
+// Lines that are not absolute file locations are left untouched.
+func trimStackPaths(stack string) string {
+	lines := strings.Split(stack, "\n")
+	pkg := ""
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "\t") {
+			pkg = framePackage(line)
+			continue
+		}
+		if pkg == "" {
+			continue
+		}
+		lines[i] = "\t" + trimFileLocation(strings.TrimPrefix(line, "\t"), pkg)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// framePackage extracts the package import path from a stack frame's
+// function line, e.g. "github.com/x/y/pkg.(*T).M(0x1)" → "github.com/x/y/pkg".
+// Returns "" for lines that are not function lines.
+func framePackage(line string) string {
+	name := strings.TrimPrefix(line, "created by ")
+	if i := strings.Index(name, " in goroutine "); i >= 0 {
+		name = name[:i]
+	}
+	if strings.HasSuffix(name, ")") {
+		if i := strings.LastIndex(name, "("); i >= 0 {
+			name = name[:i]
+		}
+	}
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return ""
+	}
+
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	pkg := name[:slash+1+dot]
+	// A major version suffix such as gopkg.in/yaml.v3 belongs to the path.
+	if rest := name[len(pkg)+1:]; isVersionElement(rest) {
+		pkg += "." + rest[:strings.Index(rest, ".")]
+	}
+	if pkg == "main" {
+		return mainPackagePath()
+	}
+	return pkg
+}
+
+// isVersionElement reports whether s starts with a "vN." major version
+// element, as after "gopkg.in/yaml" in "gopkg.in/yaml.v3.(*Decoder).Decode".
+func isVersionElement(s string) bool {
+	end := strings.Index(s, ".")
+	if end < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, r := range s[1:end] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// trimFileLocation rewrites "<abs path>:<line> +0x.." to "<pkg>/<file>:<line> +0x..".
+func trimFileLocation(location, pkg string) string {
+	loc, suffix := location, ""
+	if i := strings.Index(location, " +0x"); i >= 0 {
+		loc, suffix = location[:i], location[i:]
+	}
+	colon := strings.LastIndex(loc, ":")
+	if colon < 0 {
+		return location
+	}
+	file, lineNo := loc[:colon], loc[colon:]
+	if !isAbsPath(file) {
+		return location
+	}
+	base := file[strings.LastIndexAny(file, `/\`)+1:]
+	return pkg + "/" + base + lineNo + suffix
+}
+
+// isAbsPath reports whether p is an absolute Unix or Windows path.
+// Go stack traces use forward slashes on every platform.
+func isAbsPath(p string) bool {
+	if strings.HasPrefix(p, "/") {
+		return true
+	}
+	return len(p) >= 3 && p[1] == ':' && (p[2] == '/' || p[2] == '\\')
+}
+
+// elidedFramesMarker matches the marker the Go runtime itself prints when it
+// truncates a traceback.
+const elidedFramesMarker = "...additional frames elided..."
+
+// limitStackFrames keeps the goroutine header and the top maxFrames frames of
+// a Go stack trace, replacing the rest with elidedFramesMarker. Each frame is a
+// function line followed by its tab-indented file location. A maxFrames of
+// zero or less leaves the stack unchanged.
+func limitStackFrames(stack string, maxFrames int) string {
+	if maxFrames <= 0 {
+		return stack
+	}
+	lines := strings.Split(strings.TrimRight(stack, "\n"), "\n")
+	frames := 0
+	for i, line := range lines {
+		if i == 0 && strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			continue
+		}
+		if frames == maxFrames {
+			return strings.Join(lines[:i], "\n") + "\n" + elidedFramesMarker + "\n"
+		}
+		frames++
+	}
+	return stack
+}
+
+// bugstrPackage returns this package's import path, as it appears in frame
+// function names.
+var bugstrPackage = sync.OnceValue(func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	return framePackage(runtime.FuncForPC(pc).Name())
+})
+
+// isInternalFrame reports whether a frame, given as its function line and
+// source file, belongs to bugstr itself or to the runtime machinery behind
+// panics and deferred calls. Frames from this package's tests count as
+// user code.
+func isInternalFrame(function, file string) bool {
+	if strings.HasPrefix(function, "panic(") {
+		return true
+	}
+	if strings.HasPrefix(function, "main.") {
+		return false
+	}
+	pkg := framePackage(function)
+	if pkg == "runtime" || strings.HasPrefix(pkg, "runtime/") {
+		return true
+	}
+	return pkg == bugstrPackage() && !strings.HasSuffix(file, "_test.go")
+}
+
+// reentrantCapture reports whether the current capture was made by code
+// that bugstr itself called, such as a BeforeSend, OnError, or Transport
+// that captures its own failure. The stack then holds bugstr frames below
+// the caller's frames, and reporting would risk a feedback loop. A Recover
+// frame alone doesn't count: it stays live while its re-panic runs the
+// caller's deferred calls, which may panic anew.
+func reentrantCapture() bool {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	recoverFunc := bugstrPackage() + ".Recover"
+	inCaller := false
+	for {
+		frame, more := frames.Next()
+		switch {
+		case frame.Function == recoverFunc:
+		case !isInternalFrame(frame.Function, frame.File):
+			inCaller = true
+		case inCaller && framePackage(frame.Function) == bugstrPackage():
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// goroutineID returns the current goroutine's ID, parsed from the
+// "goroutine N [...]" header of its stack trace.
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := strings.TrimPrefix(string(buf[:runtime.Stack(buf[:], false)]), "goroutine ")
+	if i := strings.IndexByte(header, ' '); i >= 0 {
+		header = header[:i]
+	}
+	id, _ := strconv.ParseUint(header, 10, 64)
+	return id
+}
+
+// captureSite returns the file:line of the innermost caller outside bugstr,
+// e.g. where CaptureMessage was called or where a recovered panic started.
+// With trim set, the path is reduced to the package import path as in
+// trimStackPaths.
+func captureSite(trim bool) string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !isInternalFrame(frame.Function, frame.File) {
+			location := frame.File + ":" + strconv.Itoa(frame.Line)
+			if trim {
+				location = trimFileLocation(location, framePackage(frame.Function))
+			}
+			return location
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// trimInternalFrames removes the leading frames of a Go stack trace that
+// belong to bugstr or the runtime (captureStack, Recover, panic, ...), so
+// the trace starts at the user's code. The goroutine header is kept. A
+// stack with no user frames is returned unchanged.
+func trimInternalFrames(stack string) string {
+	lines := strings.Split(stack, "\n")
+	start := 0
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "goroutine ") {
+		start = 1
+	}
+	i := start
+	for i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+		location := strings.TrimPrefix(lines[i+1], "\t")
+		if colon := strings.LastIndex(location, ":"); colon >= 0 {
+			location = location[:colon]
+		}
+		if !isInternalFrame(lines[i], location) {
+			break
+		}
+		i += 2
+	}
+	if i == start || i+1 >= len(lines) {
+		return stack
+	}
+	return strings.Join(append(lines[:start:start], lines[i:]...), "\n")
+}
@@ -0,0 +1,126 @@
+package bugstr
+
+import (
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestTrimStackPaths(t *testing.T) {
+	stack := "goroutine 1 [running]:\n" +
+		"github.com/x/y/pkg.(*T).M(0x1)\n" +
+		"\t/home/bob/go/pkg/mod/github.com/x/y@v1.2.3/pkg/t.go:34 +0x55\n" +
+		"runtime/debug.Stack()\n" +
+		"\t/usr/local/go/src/runtime/debug/stack.go:26 +0x5e\n" +
+		"created by net/http.(*Server).Serve in goroutine 1\n" +
+		"\tC:/Users/alice/go/src/net/http/server.go:3285 +0x4b4\n"
+
+	want := "goroutine 1 [running]:\n" +
+		"github.com/x/y/pkg.(*T).M(0x1)\n" +
+		"\tgithub.com/x/y/pkg/t.go:34 +0x55\n" +
+		"runtime/debug.Stack()\n" +
+		"\truntime/debug/stack.go:26 +0x5e\n" +
+		"created by net/http.(*Server).Serve in goroutine 1\n" +
+		"\tnet/http/server.go:3285 +0x4b4\n"
+
+	if got := trimStackPaths(stack); got != want {
+		t.Fatalf("trimStackPaths mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTrimStackPathsKeepsRelativePaths(t *testing.T) {
+	stack := "main.main()\n\tgithub.com/x/y/main.go:10 +0x1d\n"
+	if got := trimStackPaths(stack); got != stack {
+		t.Fatalf("expected already-trimmed stack unchanged, got %q", got)
+	}
+}
+
+func TestLimitStackFrames(t *testing.T) {
+	stack := "goroutine 1 [running]:\n" +
+		"main.a()\n\tmain.go:1 +0x1\n" +
+		"main.b()\n\tmain.go:2 +0x2\n" +
+		"main.c()\n\tmain.go:3 +0x3\n"
+
+	want := "goroutine 1 [running]:\n" +
+		"main.a()\n\tmain.go:1 +0x1\n" +
+		"main.b()\n\tmain.go:2 +0x2\n" +
+		elidedFramesMarker + "\n"
+
+	if got := limitStackFrames(stack, 2); got != want {
+		t.Fatalf("limitStackFrames mismatch\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got := limitStackFrames(stack, 3); got != stack {
+		t.Fatalf("expected stack within limit unchanged, got %q", got)
+	}
+	if got := limitStackFrames(stack, 0); got != stack {
+		t.Fatalf("expected zero limit to be unlimited, got %q", got)
+	}
+}
+
+func TestCaptureSiteIsCaller(t *testing.T) {
+	payload := (&client{}).buildPayload(CaptureEvent{Message: "where"})
+	if !strings.HasPrefix(payload.CaptureSite, bugstrPackage()+"/stack_test.go:") {
+		t.Fatalf("unexpected capture site %q", payload.CaptureSite)
+	}
+
+	untrimmed := captureSite(false)
+	if !isAbsPath(untrimmed) || !strings.Contains(untrimmed, "stack_test.go:") {
+		t.Fatalf("untrimmed site should be an absolute path, got %q", untrimmed)
+	}
+}
+
+func TestFramePackage(t *testing.T) {
+	for line, want := range map[string]string{
+		"github.com/x/y/pkg.(*T).M(0x1)":                       "github.com/x/y/pkg",
+		"github.com/x/y/pkg.F.func1()":                         "github.com/x/y/pkg",
+		"gopkg.in/yaml.v3.(*Decoder).Decode(0xc000010000)":     "gopkg.in/yaml.v3",
+		"gopkg.in/yaml.v3.Unmarshal({0xc000100000, 0x5, 0x5})": "gopkg.in/yaml.v3",
+		"created by gopkg.in/yaml.v3.run in goroutine 6":       "gopkg.in/yaml.v3",
+		"net/http.(*conn).serve(0xc0000a2000)":                 "net/http",
+		"\t/src/app/main.go:12 +0x4":                           "",
+	} {
+		if got := framePackage(line); got != want {
+			t.Errorf("framePackage(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestMainPackagePath(t *testing.T) {
+	info := &debug.BuildInfo{Path: "example.com/mp/cmd/app", Main: debug.Module{Path: "example.com/mp"}}
+	if got := mainPackagePathFrom(info); got != "example.com/mp/cmd/app" {
+		t.Fatalf("main package path = %q, want the package under cmd/", got)
+	}
+	if got := mainPackagePathFrom(nil); got != "main" {
+		t.Fatalf("without build info = %q, want main", got)
+	}
+}
+
+func TestTrimInternalFrames(t *testing.T) {
+	pkg := bugstrPackage()
+	stack := "goroutine 1 [running]:\n" +
+		pkg + ".captureStack()\n" +
+		"\t/src/bugstr/bugstr.go:900 +0x1\n" +
+		pkg + ".Recover()\n" +
+		"\t/src/bugstr/bugstr.go:400 +0x2\n" +
+		"panic({0x1, 0x2})\n" +
+		"\t/usr/local/go/src/runtime/panic.go:770 +0x3\n" +
+		"main.handler()\n" +
+		"\t/src/app/main.go:12 +0x4\n"
+	want := "goroutine 1 [running]:\n" +
+		"main.handler()\n" +
+		"\t/src/app/main.go:12 +0x4\n"
+	if got := trimInternalFrames(stack); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	internalOnly := "goroutine 1 [running]:\n" + pkg + ".captureStack()\n\t/src/bugstr/bugstr.go:900 +0x1\n"
+	if got := trimInternalFrames(internalOnly); got != internalOnly {
+		t.Fatalf("stack without user frames should be unchanged, got:\n%s", got)
+	}
+
+	payload := (&client{}).buildPayload(CaptureEvent{Message: "top"})
+	lines := strings.SplitN(payload.Stack, "\n", 3)
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], pkg+".TestTrimInternalFrames(") {
+		t.Fatalf("captured stack should start at the test, got:\n%s", payload.Stack)
+	}
+}
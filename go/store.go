@@ -0,0 +1,142 @@
+package bugstr
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+)
+
+// Store persists small pieces of state across process restarts for
+// features such as deduplication windows. Implementations must be safe for
+// concurrent use. Persistence is best-effort: Set and Delete failures are
+// not reported, and a failed Get reads as missing.
+//
+// Set Config.Store to replace the default FileStore, e.g. with a
+// MemoryStore in tests or a platform keychain on mobile.
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, val []byte)
+	Delete(key string)
+}
+
+// FileStore is a Store keeping one file per key in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	// Encode keys so any string is a safe file name.
+	return filepath.Join(s.dir, base64.RawURLEncoding.EncodeToString([]byte(key)))
+}
+
+// Get returns the value stored under key.
+func (s *FileStore) Get(key string) ([]byte, bool) {
+	val, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+// Set stores val under key. The file is replaced atomically, so a crash
+// mid-write leaves the previous value.
+func (s *FileStore) Set(key string, val []byte) {
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(val)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// Delete removes key.
+func (s *FileStore) Delete(key string) {
+	os.Remove(s.path(key))
+}
+
+// MemoryStore is a Store that keeps state in memory only, for tests or
+// processes that shouldn't write to disk. The zero value is ready to use.
+type MemoryStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+// Get returns the value stored under key.
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.values[key]
+	return append([]byte(nil), val...), ok
+}
+
+// Set stores a copy of val under key.
+func (s *MemoryStore) Set(key string, val []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string][]byte)
+	}
+	s.values[key] = append([]byte(nil), val...)
+}
+
+// Delete removes key.
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// store returns Config.Store, or the default FileStore under the user
+// cache directory, falling back to a MemoryStore if that is unavailable.
+// The default is namespaced per app (see storeNamespace), so one program's
+// remembered consent never applies to another.
+func (c *client) store() Store {
+	if c.config.Store != nil {
+		return c.config.Store
+	}
+	c.defaultStoreOnce.Do(func() {
+		if dir, err := os.UserCacheDir(); err == nil {
+			if fs, err := NewFileStore(filepath.Join(dir, "bugstr", c.storeNamespace())); err == nil {
+				c.defaultStore = fs
+				return
+			}
+		}
+		c.defaultStore = &MemoryStore{}
+	})
+	return c.defaultStore
+}
+
+// storeNamespace names the default store directory: a hash of the
+// developer pubkey and the app's main package path, or its executable path
+// when the package can't be told apart (e.g. under go run of a file).
+func (c *client) storeNamespace() string {
+	app := ""
+	if info, ok := debug.ReadBuildInfo(); ok && info.Path != "command-line-arguments" {
+		app = info.Path
+	}
+	if app == "" {
+		app, _ = os.Executable()
+	}
+	sum := sha256.Sum256([]byte(c.developerPubkeyHex + "\x00" + app))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,42 @@
+package bugstr
+
+import "testing"
+
+func TestStores(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	for name, s := range map[string]Store{"file": fs, "memory": &MemoryStore{}} {
+		if _, ok := s.Get("../odd key"); ok {
+			t.Fatalf("%s: unexpected value before Set", name)
+		}
+		s.Set("../odd key", []byte("v1"))
+		s.Set("../odd key", []byte("v2"))
+		if val, ok := s.Get("../odd key"); !ok || string(val) != "v2" {
+			t.Fatalf("%s: Get = %q, %v", name, val, ok)
+		}
+		s.Delete("../odd key")
+		if _, ok := s.Get("../odd key"); ok {
+			t.Fatalf("%s: value survived Delete", name)
+		}
+	}
+}
+
+func TestConfigStoreOverridesDefault(t *testing.T) {
+	mem := &MemoryStore{}
+	if got := (&client{config: Config{Store: mem}}).store(); got != mem {
+		t.Fatalf("Config.Store should be used, got %T", got)
+	}
+}
+
+func TestDefaultStoreIsPerApp(t *testing.T) {
+	a := &client{developerPubkeyHex: "aa"}
+	b := &client{developerPubkeyHex: "bb"}
+	if a.storeNamespace() == b.storeNamespace() {
+		t.Fatal("apps of different developers should not share a default store")
+	}
+	if a.storeNamespace() != (&client{developerPubkeyHex: "aa"}).storeNamespace() {
+		t.Fatal("the namespace should be stable across runs of one app")
+	}
+}
@@ -0,0 +1,85 @@
+package bugstr
+
+import (
+	"context"
+	"sync"
+)
+
+// testReportMessage is the message of the synthetic report sent by
+// SendTestReport.
+const testReportMessage = "bugstr test report"
+
+// Report describes a report sent by SendTestReport.
+type Report struct {
+	// Payload is the report as delivered, after redaction.
+	Payload *Payload
+	// EventIDs are the IDs of the gift wrap events published for the
+	// report. Empty when a custom Config.Transport does not publish
+	// through DefaultTransport.
+	EventIDs []string
+}
+
+// SendTestReport sends a harmless synthetic crash report through the same
+// redact, compress, gift wrap, and publish path as a real crash, and waits
+// for delivery. Use it to verify crash reporting end to end, e.g. from a
+// "test my crash reporting" button:
+//
+//	report, err := bugstr.SendTestReport(ctx)
+//
+// The test report carries a "bugstr_test" tag. It bypasses MinLevel,
+// IgnoreErrors, IgnoreTypes, BeforeSend, and ConfirmSend so that it is
+// always sent; everything after those runs exactly as in production.
+// Because it skips consent, it carries no user data: the stack, LogTap
+// lines, DeviceInfoFunc output, and SetContext values are left out.
+func SendTestReport(ctx context.Context) (Report, error) {
+	c := currentClient()
+	if c == nil {
+		return Report{}, ErrNotInitialized
+	}
+	payload := c.buildPayload(CaptureEvent{
+		Message: testReportMessage,
+		Level:   LevelInfo,
+		Tags:    map[string]string{"bugstr_test": "true"},
+	})
+	payload.Stack = ""
+	payload.Logs = nil
+	payload.Device = nil
+	payload.Contexts = nil
+
+	rec := &eventRecorder{}
+	err := c.deliver(withEventRecorder(ctx, rec), payload)
+	return Report{Payload: payload, EventIDs: rec.ids()}, err
+}
+
+// eventRecorder collects the IDs of gift wraps published for a report.
+type eventRecorder struct {
+	mu       sync.Mutex
+	eventIDs []string
+}
+
+func (r *eventRecorder) record(id string) {
+	r.mu.Lock()
+	r.eventIDs = append(r.eventIDs, id)
+	r.mu.Unlock()
+}
+
+func (r *eventRecorder) ids() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.eventIDs...)
+}
+
+type eventRecorderKey struct{}
+
+// withEventRecorder returns a context that makes sendToNostr record the
+// IDs of the gift wraps it publishes in rec.
+func withEventRecorder(ctx context.Context, rec *eventRecorder) context.Context {
+	return context.WithValue(ctx, eventRecorderKey{}, rec)
+}
+
+// recordEventID records id in the context's eventRecorder, if any.
+func recordEventID(ctx context.Context, id string) {
+	if rec, ok := ctx.Value(eventRecorderKey{}).(*eventRecorder); ok {
+		rec.record(id)
+	}
+}
@@ -0,0 +1,40 @@
+package bugstr
+
+import "context"
+
+// Transport delivers a finished, redacted report.
+//
+// The default implementation, DefaultTransport, gift-wraps the report per
+// NIP-17 and publishes it to the configured relays. Set Config.Transport to
+// replace it, e.g. with a fake in tests or an alternative backend.
+//
+// Send is called from a background goroutine with a context bounded by the
+// send timeout. Implementations must be safe for concurrent use.
+type Transport interface {
+	Send(ctx context.Context, report *Payload) error
+}
+
+// TransportFunc adapts an ordinary function to the Transport interface.
+type TransportFunc func(ctx context.Context, report *Payload) error
+
+// Send calls f(ctx, report).
+func (f TransportFunc) Send(ctx context.Context, report *Payload) error {
+	return f(ctx, report)
+}
+
+// DefaultTransport delivers reports as NIP-17 gift-wrapped DMs to
+// Config.DeveloperPubkey via Config.Relays. It is used when
+// Config.Transport is nil, and can be wrapped to extend it.
+var DefaultTransport Transport = nostrTransport{}
+
+// nostrTransport is the NIP-17 gift wrap transport.
+type nostrTransport struct{}
+
+// Send gift-wraps and publishes the report.
+func (nostrTransport) Send(ctx context.Context, report *Payload) error {
+	c := currentClient()
+	if c == nil {
+		return ErrNotInitialized
+	}
+	return c.sendToNostr(ctx, report)
+}
@@ -0,0 +1,60 @@
+package bugstr
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// relayEventSizeLimit is a common relay cap on event size (e.g. strfry's
+// default maxEventSize); larger gift wraps are likely to be rejected.
+const relayEventSizeLimit = 64 << 10
+
+// ValidatePayload checks p against the active configuration, or the
+// defaults before Init, and returns human-readable warnings, e.g. for a
+// message over Config.MaxMessageLength or text that still matches a
+// redaction pattern. It returns nil for a clean payload. Use it in tests,
+// or from BeforeSend while debugging redaction.
+func ValidatePayload(p *Payload) []string {
+	c := currentClient()
+	if c == nil {
+		c = &client{}
+	}
+
+	var warnings []string
+	if p.Message == "" {
+		warnings = append(warnings, "message is empty")
+	}
+	if limit := c.config.MaxMessageLength; limit > 0 && len(p.Message) > limit {
+		warnings = append(warnings, fmt.Sprintf("message is %d bytes, exceeds MaxMessageLength (%d)", len(p.Message), limit))
+	}
+
+	fields := map[string]string{"message": p.Message, "stack": p.Stack}
+	for k, v := range p.Tags {
+		fields[fmt.Sprintf("tag %q", k)] = v
+	}
+	for i, line := range p.Logs {
+		fields[fmt.Sprintf("log line %d", i)] = line
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v := fields[name]; c.redact(v) != v {
+			warnings = append(warnings, name+" contains unredacted text matching a redaction pattern")
+		}
+	}
+
+	plaintext, err := json.Marshal(p)
+	if err != nil {
+		return append(warnings, "payload does not encode as JSON: "+err.Error())
+	}
+	// Seal and gift wrap each base64-encode their NIP-44 ciphertext, so the
+	// wrap is about (4/3)^2 the rumor content.
+	if size := len(c.encodeContent(plaintext)) * 16 / 9; size > relayEventSizeLimit {
+		warnings = append(warnings, fmt.Sprintf("gift wrap will be about %d bytes; many relays reject events over %d", size, relayEventSizeLimit))
+	}
+	return warnings
+}
@@ -0,0 +1,37 @@
+package bugstr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePayload(t *testing.T) {
+	resetForTest(t)
+	t.Cleanup(func() { resetForTest(t) })
+
+	clean := (&client{}).buildPayload(CaptureEvent{Message: "pay lnbc1abc failed"})
+	if warnings := ValidatePayload(clean); warnings != nil {
+		t.Fatalf("built payload should be clean, got %v", warnings)
+	}
+
+	active.Store(&client{config: Config{MaxMessageLength: 10}})
+	warnings := ValidatePayload(&Payload{
+		Message: "leaked nsec1abc in a long message",
+		Tags:    map[string]string{"user": "npub1xyz"},
+	})
+	want := []string{"exceeds MaxMessageLength", "message contains unredacted", `tag "user" contains unredacted`}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %v", warnings)
+	}
+	for i, w := range want {
+		if !strings.Contains(warnings[i], w) {
+			t.Errorf("warning %d = %q, want it to mention %q", i, warnings[i], w)
+		}
+	}
+
+	active.Store(&client{config: Config{Compression: CompressionNone}})
+	huge := &Payload{Message: "x", Stack: strings.Repeat("frame\n", relayEventSizeLimit/6)}
+	if warnings := ValidatePayload(huge); len(warnings) != 1 || !strings.Contains(warnings[0], "gift wrap will be about") {
+		t.Fatalf("oversized payload warnings = %v", warnings)
+	}
+}
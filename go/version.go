@@ -0,0 +1,70 @@
+package bugstr
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// sdkName prefixes Payload.SDK.
+const sdkName = "bugstr-go"
+
+// sdkVersion returns the bugstr module version the binary was built with,
+// e.g. "v0.3.1", or "devel" for local builds without module versions.
+var sdkVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	modules := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, m := range modules {
+		if m.Path != bugstrPackage() {
+			continue
+		}
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		if m.Version != "" && m.Version != "(devel)" {
+			return m.Version
+		}
+	}
+	return "devel"
+})
+
+// BuildInfo describes the build of the reporting binary, read from the
+// VCS stamps Go embeds (-buildvcs, on by default in a repository).
+type BuildInfo struct {
+	// Revision is the VCS commit, e.g. a git SHA.
+	Revision string `json:"revision,omitempty"`
+	// Modified reports whether the working tree had uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+	// Time is the commit time in RFC 3339 format.
+	Time string `json:"time,omitempty"`
+}
+
+// appBuild returns the main module version ("" for devel builds) and its
+// VCS stamps, or nil if the binary carries none.
+var appBuild = sync.OnceValues(func() (string, *BuildInfo) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", nil
+	}
+	version := info.Main.Version
+	if version == "(devel)" {
+		version = ""
+	}
+	var build BuildInfo
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		case "vcs.time":
+			build.Time = setting.Value
+		}
+	}
+	if build == (BuildInfo{}) {
+		return version, nil
+	}
+	return version, &build
+})
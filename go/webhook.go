@@ -0,0 +1,43 @@
+package bugstr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds a single mirror POST. It is a variable so tests
+// can shorten it.
+var webhookTimeout = 10 * time.Second
+
+// postWebhook POSTs the redacted payload as JSON to url.
+// Any non-2xx response is treated as a failure.
+func postWebhook(ctx context.Context, url string, payload *Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bugstr: webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package bugstr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorWebhookReceivesRedactedJSON(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var got Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+		received <- r
+	}))
+	defer server.Close()
+
+	c := &client{config: Config{
+		MirrorWebhook: server.URL,
+		Transport:     TransportFunc(func(context.Context, *Payload) error { return nil }),
+	}}
+	if err := c.deliver(context.Background(), c.buildPayload(CaptureEvent{Message: "key nsec1secret"})); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	r := <-received
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("%s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+	}
+	if got.Message != "key [redacted]" {
+		t.Fatalf("webhook Message = %q, want it redacted", got.Message)
+	}
+	if n := c.health.status().MirrorFailed; n != 0 {
+		t.Fatalf("MirrorFailed = %d", n)
+	}
+}
+
+func TestMirrorWebhookFailureDoesNotFailDelivery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sent := false
+	c := &client{config: Config{
+		MirrorWebhook: server.URL,
+		Transport: TransportFunc(func(context.Context, *Payload) error {
+			sent = true
+			return nil
+		}),
+	}}
+	if err := c.deliver(context.Background(), &Payload{Message: "boom"}); err != nil || !sent {
+		t.Fatalf("deliver err = %v, sent = %v; a failing mirror must not fail delivery", err, sent)
+	}
+	if status := c.health.status(); status.MirrorFailed != 1 || status.LastError != nil {
+		t.Fatalf("health = %+v, want one mirror failure and no delivery error", status)
+	}
+
+	if err := postWebhook(context.Background(), server.URL, &Payload{}); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("postWebhook err = %v, want the 500 status", err)
+	}
+}
+
+func TestMirrorWebhookTimeout(t *testing.T) {
+	defer func(old time.Duration) { webhookTimeout = old }(webhookTimeout)
+	webhookTimeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := &client{config: Config{
+		MirrorWebhook: server.URL,
+		Transport:     TransportFunc(func(context.Context, *Payload) error { return nil }),
+	}}
+	start := time.Now()
+	if err := c.deliver(context.Background(), &Payload{Message: "boom"}); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("deliver took %v with a hanging webhook", elapsed)
+	}
+	if n := c.health.status().MirrorFailed; n != 1 {
+		t.Fatalf("MirrorFailed = %d, want the timeout counted", n)
+	}
+}